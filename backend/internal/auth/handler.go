@@ -2,10 +2,11 @@ package auth
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"time"
+	"strconv"
+	"video-chat/internal/mailer"
 	"video-chat/internal/utils"
 
 	"github.com/gin-gonic/gin"
@@ -17,13 +18,17 @@ import (
 type AuthHandler struct {
 	server *AuthServer
 	redisClient *redis.Client
+	mailer *mailer.Sender
+	otp otpPolicy
 	ctx context.Context
 }
 
-func NewAuthHandler(server *AuthServer, redisClient *redis.Client) *AuthHandler {
+func NewAuthHandler(server *AuthServer, redisClient *redis.Client, mail mailer.Mailer) *AuthHandler {
 	return &AuthHandler{
 		server: server,
 		redisClient: redisClient,
+		mailer: mailer.NewSender(mail),
+		otp: loadOTPPolicy(),
 		ctx: context.Background(),
 	}
 }
@@ -62,8 +67,11 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		link := fmt.Sprintf("%s/verify-account?email=%s&verifyId=%s",
 			utils.GetEnvOrDefaultValue("UI_HOST", "localhost:3000"), draftUser.Email, draftUser.VerifyID)
 		
-		// TODO: Send verification email with link
-		fmt.Printf("Resending verification link to %s: %s\n", draftUser.Email, link)
+		if err := h.mailer.Send(mailer.VerificationEmail(draftUser.Email, draftUser.FirstName, link)); err != nil {
+			fmt.Printf("Failed to resend verification link to %s: %v\n", draftUser.Email, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+			return
+		}
 		
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Verification link resent to email",
@@ -87,8 +95,11 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	link := fmt.Sprintf("%s/verify-account?email=%s&verifyId=%s",
 		utils.GetEnvOrDefaultValue("UI_HOST", "localhost:3000"), newDraftUser.Email, newDraftUser.VerifyID)
 	
-	// TODO: Send verification email with link
-	fmt.Printf("Sending verification link to %s: %s\n", newDraftUser.Email, link)
+	if err := h.mailer.Send(mailer.VerificationEmail(newDraftUser.Email, newDraftUser.FirstName, link)); err != nil {
+		fmt.Printf("Failed to send verification link to %s: %v\n", newDraftUser.Email, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Verification link sent to email",
@@ -116,18 +127,26 @@ func (h *AuthHandler) SendOTP(ctx *gin.Context) {
 	}
 	
 	otp := utils.GenerateOTP()
-	otpData, _ := json.Marshal(otp)
 
-	// Store otp in redis for 1hour
-	key := fmt.Sprintf("otp-%s", user.ID)
-	if err := h.redisClient.Set(h.ctx, key, otpData, time.Hour).Err(); err != nil {
+	// Store otp in redis, subject to the resend cooldown and lockout
+	wait, err := h.issueOTP(user.ID, otp)
+	if errors.Is(err, ErrOTPCooldown) || errors.Is(err, ErrOTPLocked) {
+		ctx.Header("Retry-After", strconv.Itoa(int(wait.Seconds())))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
 		fmt.Printf("Failed to store OTP in Redis: %v\n", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create OTP"})
 		return
 	}
 
-	// TODO: send OTP to user via email
-	fmt.Printf("Generated OTP for user %s: %s\n", user.Email, otp)
+	if err := h.mailer.Send(mailer.OTPEmail(user.Email, user.FirstName, otp, h.otp.TTL)); err != nil {
+		fmt.Printf("Failed to send OTP to %s: %v\n", user.Email, err)
+		h.cancelOTP(user.ID)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send OTP"})
+		return
+	}
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "OTP sent to mail",
@@ -207,6 +226,8 @@ func (h *AuthHandler) VerifyAccount(ctx *gin.Context) {
 type VerifyOTPRequest struct {
 	Email string `json:"email" binding:"required,email"`
 	Otp string 	`json:"otp" binding:"required"`
+	// Device optionally names the client, e.g. "Work laptop"
+	Device string `json:"device"`
 }
 
 func (h *AuthHandler) VerifyOTP(ctx *gin.Context) {
@@ -224,31 +245,29 @@ func (h *AuthHandler) VerifyOTP(ctx *gin.Context) {
 		return
 	}
 
-	key := fmt.Sprintf("otp-%s", user.ID)
-	data, err := h.redisClient.Get(h.ctx, key).Result()
-	if err != nil {
-		fmt.Printf("Failed to get OTP from Redis for user %s: %v\n", user.Email, err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "User doesnot exist"})
+	remaining, err := h.consumeOTP(user.ID, req.Otp)
+	switch {
+	case errors.Is(err, ErrOTPInvalid):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "attemptsLeft": remaining})
 		return
-	}
-
-	var otpData string
-	json.Unmarshal([]byte(data), &otpData)
-
-	fmt.Println(otpData, otpData)
-
-	if otpData != req.Otp {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid OTP"})
+	case errors.Is(err, ErrOTPExpired):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrOTPLocked):
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		fmt.Printf("Failed to verify OTP for user %s: %v\n", user.Email, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify OTP"})
 		return
 	}
 
-	token := uuid.NewString()
-	tokenData, _ := json.Marshal(user.ID)
-	if err := h.redisClient.Set(h.ctx, token, tokenData, 24*7*time.Hour).Err(); err != nil {
+	token, err := h.createSession(ctx, user.ID, req.Device)
+	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
-	ctx.SetCookie("token", token, int((24 * 7 * time.Hour).Seconds()), "/", "", false, true)
+	ctx.SetCookie("token", token, int(sessionTTL.Seconds()), "/", "", false, true)
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "User account confirmed successfully",
@@ -301,18 +320,111 @@ func (h *AuthHandler) DeleteAccount(ctx *gin.Context) {
 		return
 	}
 
+	// Delete every session of the user from Redis
+	if _, err := h.revokeSessions(userId, ""); err != nil {
+		// Log error but continue since user is already deleted
+		fmt.Printf("Error deleting sessions: %v\n", err)
+	}
+	if token, err := ctx.Cookie("token"); err == nil {
+		h.redisClient.Del(h.ctx, token)
+	}
+
 	// Clear the auth cookie
 	ctx.SetCookie("token", "", -1, "/", "", false, true)
 
-	// Delete session from Redis
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Account deleted successfully",
+	})
+
+}
+
+// List the logged in user's sessions
+func (h *AuthHandler) ListSessions(ctx *gin.Context) {
+	userId := ctx.GetString("userId")
+	currentId := ctx.GetString("sessionId")
+
+	sessions, err := h.listSessions(userId)
+	if err != nil {
+		fmt.Printf("Error listing sessions: %v\n", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, gin.H{
+			"id":         session.ID,
+			"device":     session.Device,
+			"userAgent":  session.UserAgent,
+			"ip":         session.IP,
+			"createdAt":  session.CreatedAt,
+			"lastSeenAt": session.LastSeenAt,
+			"current":    session.ID == currentId,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":  "Fetched sessions successfully",
+		"sessions": result,
+	})
+}
+
+// Logout ends the current session
+func (h *AuthHandler) Logout(ctx *gin.Context) {
+	userId := ctx.GetString("userId")
 	token, _ := ctx.Cookie("token")
+
+	if sessionId := ctx.GetString("sessionId"); sessionId != "" {
+		h.redisClient.HDel(h.ctx, sessionIndexKey(userId), sessionId)
+	}
 	if err := h.redisClient.Del(h.ctx, token).Err(); err != nil {
-		// Log error but continue since user is already deleted
 		fmt.Printf("Error deleting session: %v\n", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
+
+	ctx.SetCookie("token", "", -1, "/", "", false, true)
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// RevokeSession ends one of the user's sessions, e.g. a lost device
+func (h *AuthHandler) RevokeSession(ctx *gin.Context) {
+	userId := ctx.GetString("userId")
+	sessionId := ctx.Param("sessionId")
+
+	err := h.revokeSession(userId, sessionId)
+	if errors.Is(err, ErrSessionNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error revoking session: %v\n", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	if sessionId == ctx.GetString("sessionId") {
+		ctx.SetCookie("token", "", -1, "/", "", false, true)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// RevokeOtherSessions ends every session except the current one
+func (h *AuthHandler) RevokeOtherSessions(ctx *gin.Context) {
+	userId := ctx.GetString("userId")
+	sessionId := ctx.GetString("sessionId")
+
+	revoked, err := h.revokeSessions(userId, sessionId)
+	if err != nil {
+		fmt.Printf("Error revoking sessions: %v\n", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "Account deleted successfully",
+		"message": "Other sessions revoked successfully",
+		"revoked": revoked,
 	})
-
 }
\ No newline at end of file
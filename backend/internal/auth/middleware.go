@@ -1,7 +1,7 @@
 package auth
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -19,24 +19,25 @@ func (h *AuthHandler) AuthMiddleware(client *redis.Client) gin.HandlerFunc {
 			return
 		}
 
-		data, err := client.Get(ctx.Request.Context(), token).Result()
-		if err != nil {
+		session, err := h.getSession(token)
+		if errors.Is(err, redis.Nil) {
 			fmt.Printf("Auth failed: invalid token - %v", err)
 			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			ctx.Abort()
 			return
 		}
-
-		var userID string
-		if err := json.Unmarshal([]byte(data), &userID); err != nil {
-			fmt.Printf("Auth failed: error unmarshaling userID - %v", err)
+		if err != nil {
+			fmt.Printf("Auth failed: error reading session - %v", err)
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 			ctx.Abort()
 			return
 		}
 
-		fmt.Printf("Auth successful for user: %s", userID)
-		ctx.Set("userId", userID)
+		h.touchSession(token, session)
+
+		fmt.Printf("Auth successful for user: %s", session.UserID)
+		ctx.Set("userId", session.UserID)
+		ctx.Set("sessionId", session.ID)
 		ctx.Next()
 	}
 }
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"video-chat/internal/utils"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrOTPLocked   = errors.New("Too many failed attempts, try again later")
+	ErrOTPCooldown = errors.New("Please wait before requesting another OTP")
+	ErrOTPExpired  = errors.New("OTP expired or not requested")
+	ErrOTPInvalid  = errors.New("Invalid OTP")
+)
+
+// otpPolicy controls OTP lifetime and abuse limits. Values come from the
+// environment so they can be tuned per deployment.
+type otpPolicy struct {
+	TTL            time.Duration
+	ResendCooldown time.Duration
+	MaxAttempts    int64
+	Lockout        time.Duration
+}
+
+func loadOTPPolicy() otpPolicy {
+	return otpPolicy{
+		TTL:            envDuration("OTP_TTL", 10*time.Minute),
+		ResendCooldown: envDuration("OTP_RESEND_COOLDOWN", time.Minute),
+		MaxAttempts:    envInt("OTP_MAX_ATTEMPTS", 5),
+		Lockout:        envDuration("OTP_LOCKOUT", 15*time.Minute),
+	}
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(utils.GetEnvOrDefaultValue(key, fallback.String()))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func envInt(key string, fallback int64) int64 {
+	value, err := strconv.ParseInt(utils.GetEnvOrDefaultValue(key, ""), 10, 64)
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func otpKey(userId string) string {
+	return fmt.Sprintf("otp-%s", userId)
+}
+
+func otpAttemptsKey(userId string) string {
+	return fmt.Sprintf("otp-attempts-%s", userId)
+}
+
+func otpCooldownKey(userId string) string {
+	return fmt.Sprintf("otp-cooldown-%s", userId)
+}
+
+func otpLockKey(userId string) string {
+	return fmt.Sprintf("otp-locked-%s", userId)
+}
+
+// issueOTP stores a fresh OTP for the user unless they are locked out or
+// asked for one too recently. It returns how long to wait on ErrOTPCooldown.
+// The cooldown is taken first so concurrent requests issue a single code,
+// call cancelOTP if the code can't be delivered.
+func (h *AuthHandler) issueOTP(userId, otp string) (time.Duration, error) {
+	locked, err := h.redisClient.Exists(h.ctx, otpLockKey(userId)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if locked > 0 {
+		ttl, _ := h.redisClient.TTL(h.ctx, otpLockKey(userId)).Result()
+		return ttl, ErrOTPLocked
+	}
+
+	ok, err := h.redisClient.SetNX(h.ctx, otpCooldownKey(userId), 1, h.otp.ResendCooldown).Result()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		ttl, _ := h.redisClient.TTL(h.ctx, otpCooldownKey(userId)).Result()
+		return ttl, ErrOTPCooldown
+	}
+
+	otpData, _ := json.Marshal(otp)
+	if err := h.redisClient.Set(h.ctx, otpKey(userId), otpData, h.otp.TTL).Err(); err != nil {
+		h.cancelOTP(userId)
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// cancelOTP drops a code that never reached the user along with its resend
+// cooldown, so they can ask for another one right away
+func (h *AuthHandler) cancelOTP(userId string) {
+	if err := h.redisClient.Del(h.ctx, otpKey(userId), otpCooldownKey(userId)).Err(); err != nil {
+		fmt.Printf("Failed to cancel OTP of %s: %v\n", userId, err)
+	}
+}
+
+// consumeOTP checks a submitted OTP in constant time. Every submission counts
+// towards a lockout before the code is compared, and a correct code is
+// deleted so it can't be used twice. On ErrOTPInvalid it returns the number
+// of attempts left.
+func (h *AuthHandler) consumeOTP(userId, submitted string) (int64, error) {
+	locked, err := h.redisClient.Exists(h.ctx, otpLockKey(userId)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if locked > 0 {
+		return 0, ErrOTPLocked
+	}
+
+	attempts, err := utils.CountAttempt(h.ctx, h.redisClient, otpAttemptsKey(userId), h.otp.Lockout)
+	if err != nil {
+		return 0, err
+	}
+	if attempts > h.otp.MaxAttempts {
+		return 0, h.lockOTP(userId)
+	}
+
+	data, err := h.redisClient.Get(h.ctx, otpKey(userId)).Result()
+	if err == redis.Nil {
+		return 0, ErrOTPExpired
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var otp string
+	if err := json.Unmarshal([]byte(data), &otp); err != nil {
+		return 0, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(otp), []byte(submitted)) != 1 {
+		if attempts >= h.otp.MaxAttempts {
+			return 0, h.lockOTP(userId)
+		}
+		return h.otp.MaxAttempts - attempts, ErrOTPInvalid
+	}
+
+	// Only one concurrent request gets to delete the code
+	deleted, err := h.redisClient.Del(h.ctx, otpKey(userId)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if deleted == 0 {
+		return 0, ErrOTPExpired
+	}
+
+	h.redisClient.Del(h.ctx, otpAttemptsKey(userId))
+	return 0, nil
+}
+
+// lockOTP burns the user's code and locks them out for a while once they
+// ran out of attempts
+func (h *AuthHandler) lockOTP(userId string) error {
+	pipe := h.redisClient.TxPipeline()
+	pipe.Del(h.ctx, otpKey(userId), otpAttemptsKey(userId))
+	pipe.Set(h.ctx, otpLockKey(userId), 1, h.otp.Lockout)
+	if _, err := pipe.Exec(h.ctx); err != nil {
+		return err
+	}
+
+	return ErrOTPLocked
+}
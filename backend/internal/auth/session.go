@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	sessionTTL = 24 * 7 * time.Hour
+
+	// lastSeenInterval throttles how often a request rewrites its session
+	lastSeenInterval = time.Minute
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is the record stored in Redis under the session token
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"userId"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+}
+
+// sessionIndexKey maps a user's session IDs to their tokens, so sessions can
+// be listed and revoked without exposing the tokens themselves
+func sessionIndexKey(userId string) string {
+	return fmt.Sprintf("sessions-%s", userId)
+}
+
+// createSession stores a new session for the request's client and returns its token
+func (h *AuthHandler) createSession(ctx *gin.Context, userId, device string) (string, error) {
+	now := time.Now()
+	userAgent := ctx.Request.UserAgent()
+	if device == "" {
+		device = describeDevice(userAgent)
+	}
+
+	session := Session{
+		ID:         uuid.NewString(),
+		UserID:     userId,
+		Device:     device,
+		UserAgent:  userAgent,
+		IP:         ctx.ClientIP(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	data, _ := json.Marshal(session)
+
+	token := uuid.NewString()
+	pipe := h.redisClient.TxPipeline()
+	pipe.Set(h.ctx, token, data, sessionTTL)
+	pipe.HSet(h.ctx, sessionIndexKey(userId), session.ID, token)
+	pipe.Expire(h.ctx, sessionIndexKey(userId), sessionTTL)
+	if _, err := pipe.Exec(h.ctx); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// getSession loads the session behind a token. Tokens issued before session
+// records existed only hold the user ID and come back without an ID.
+func (h *AuthHandler) getSession(token string) (*Session, error) {
+	data, err := h.redisClient.Get(h.ctx, token).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal([]byte(data), &session); err == nil {
+		return &session, nil
+	}
+
+	var userId string
+	if err := json.Unmarshal([]byte(data), &userId); err != nil {
+		return nil, err
+	}
+	return &Session{UserID: userId}, nil
+}
+
+// touchSession records activity on the session, at most once per lastSeenInterval
+func (h *AuthHandler) touchSession(token string, session *Session) {
+	if session.ID == "" || time.Since(session.LastSeenAt) < lastSeenInterval {
+		return
+	}
+
+	session.LastSeenAt = time.Now()
+	data, _ := json.Marshal(session)
+	if err := h.redisClient.SetArgs(h.ctx, token, data, redis.SetArgs{KeepTTL: true, Mode: "XX"}).Err(); err != nil {
+		fmt.Printf("Failed to update session last seen: %v\n", err)
+	}
+}
+
+// listSessions returns the user's live sessions, pruning expired ones from the index
+func (h *AuthHandler) listSessions(userId string) ([]Session, error) {
+	index, err := h.redisClient.HGetAll(h.ctx, sessionIndexKey(userId)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	for sessionId, token := range index {
+		session, err := h.getSession(token)
+		if errors.Is(err, redis.Nil) {
+			h.redisClient.HDel(h.ctx, sessionIndexKey(userId), sessionId)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+
+	return sessions, nil
+}
+
+// revokeSession deletes one of the user's sessions by ID
+func (h *AuthHandler) revokeSession(userId, sessionId string) error {
+	token, err := h.redisClient.HGet(h.ctx, sessionIndexKey(userId), sessionId).Result()
+	if errors.Is(err, redis.Nil) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	pipe := h.redisClient.TxPipeline()
+	pipe.Del(h.ctx, token)
+	pipe.HDel(h.ctx, sessionIndexKey(userId), sessionId)
+	_, err = pipe.Exec(h.ctx)
+	return err
+}
+
+// revokeSessions deletes all of the user's sessions except keepSessionId
+func (h *AuthHandler) revokeSessions(userId, keepSessionId string) (int, error) {
+	index, err := h.redisClient.HGetAll(h.ctx, sessionIndexKey(userId)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	pipe := h.redisClient.TxPipeline()
+	for sessionId, token := range index {
+		if sessionId == keepSessionId {
+			continue
+		}
+		pipe.Del(h.ctx, token)
+		pipe.HDel(h.ctx, sessionIndexKey(userId), sessionId)
+		revoked++
+	}
+
+	if revoked == 0 {
+		return 0, nil
+	}
+	if _, err := pipe.Exec(h.ctx); err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
+
+// describeDevice gives a rough, human readable name for a user agent
+func describeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	platform := "Unknown device"
+	switch {
+	case strings.Contains(ua, "iphone"):
+		platform = "iPhone"
+	case strings.Contains(ua, "ipad"):
+		platform = "iPad"
+	case strings.Contains(ua, "android"):
+		platform = "Android"
+	case strings.Contains(ua, "windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "mac os"):
+		platform = "Mac"
+	case strings.Contains(ua, "linux"):
+		platform = "Linux"
+	}
+
+	browser := ""
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	}
+
+	if browser == "" {
+		return platform
+	}
+	return browser + " on " + platform
+}
@@ -13,6 +13,22 @@ type Config struct {
 	REDIS_ADDR string
 	REDIS_PASS string
 	REDIS_DB   string
+
+	MAIL_DRIVER     string
+	MAIL_FROM       string
+	MAIL_OUTBOX_DIR string
+	SMTP_HOST       string
+	SMTP_PORT       string
+	SMTP_USER       string
+	SMTP_PASS       string
+
+	// The SFU keeps its rooms in memory, so only one replica relays media at
+	// a time. Clients of the other replicas connect to each other directly.
+	SFU_ENABLED      string
+	SFU_ICE_SERVERS  string
+	SFU_PUBLIC_IP    string
+	SFU_UDP_PORT_MIN string
+	SFU_UDP_PORT_MAX string
 }
 
 func LoadConfig() *Config {
@@ -27,5 +43,19 @@ func LoadConfig() *Config {
 		REDIS_ADDR: utils.GetEnvOrDefaultValue("REDIS_ADDR", ""),
 		REDIS_PASS: utils.GetEnvOrDefaultValue("REDIS_PASS", ""),
 		REDIS_DB:   utils.GetEnvOrDefaultValue("REDIS_DB", ""),
+
+		MAIL_DRIVER:     utils.GetEnvOrDefaultValue("MAIL_DRIVER", "outbox"),
+		MAIL_FROM:       utils.GetEnvOrDefaultValue("MAIL_FROM", "Video Meet <no-reply@localhost>"),
+		MAIL_OUTBOX_DIR: utils.GetEnvOrDefaultValue("MAIL_OUTBOX_DIR", "outbox"),
+		SMTP_HOST:       utils.GetEnvOrDefaultValue("SMTP_HOST", "localhost"),
+		SMTP_PORT:       utils.GetEnvOrDefaultValue("SMTP_PORT", "587"),
+		SMTP_USER:       utils.GetEnvOrDefaultValue("SMTP_USER", ""),
+		SMTP_PASS:       utils.GetEnvOrDefaultValue("SMTP_PASS", ""),
+
+		SFU_ENABLED:      utils.GetEnvOrDefaultValue("SFU_ENABLED", "false"),
+		SFU_ICE_SERVERS:  utils.GetEnvOrDefaultValue("SFU_ICE_SERVERS", ""),
+		SFU_PUBLIC_IP:    utils.GetEnvOrDefaultValue("SFU_PUBLIC_IP", ""),
+		SFU_UDP_PORT_MIN: utils.GetEnvOrDefaultValue("SFU_UDP_PORT_MIN", ""),
+		SFU_UDP_PORT_MAX: utils.GetEnvOrDefaultValue("SFU_UDP_PORT_MAX", ""),
 	}
 }
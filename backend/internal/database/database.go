@@ -27,12 +27,24 @@ func InitMySQL(cfg *config.Config) *gorm.DB {
 		&models.RoomMember{},
 		&models.InvitedMember{},
 		&models.JoinRequest{},
+		&models.RoomInviteLink{},
+		&models.RoomBan{},
 		&models.RoomStats{},
+		&models.MeetingSession{},
+		&models.SessionParticipant{},
 		&models.Message{},
 	)
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	// Room creators used to be stored as admins, they are owners now
+	err = db.Exec(`UPDATE room_members SET role = ? FROM rooms
+		WHERE rooms.id = room_members.room_id AND rooms.created_by = room_members.user_id AND room_members.role = ?`,
+		models.RoleOwner, models.RoleAdmin).Error
+	if err != nil {
+		log.Fatal("Failed to migrate room owners:", err)
+	}
+
 	return db
 }
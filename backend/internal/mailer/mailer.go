@@ -0,0 +1,65 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"video-chat/internal/config"
+)
+
+// Timeout bounds how long a request waits for an email to be delivered
+const Timeout = 15 * time.Second
+
+var ErrUnknownDriver = errors.New("unknown MAIL_DRIVER")
+
+// Message is a rendered email ready to be delivered
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers emails to users
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// New returns the mailer selected by MAIL_DRIVER, "smtp" or "outbox". Any
+// other value is refused, so a typo can't quietly send real mail, OTP codes
+// included, to the outbox and its log.
+func New(cfg *config.Config) (Mailer, error) {
+	switch cfg.MAIL_DRIVER {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTP_HOST, cfg.SMTP_PORT, cfg.SMTP_USER, cfg.SMTP_PASS, cfg.MAIL_FROM), nil
+	case "outbox":
+		return NewOutboxMailer(cfg.MAIL_OUTBOX_DIR, cfg.MAIL_FROM), nil
+	default:
+		return nil, fmt.Errorf("%w %q, use smtp or outbox", ErrUnknownDriver, cfg.MAIL_DRIVER)
+	}
+}
+
+// Sender delivers the emails rendered by the templates on behalf of the
+// request handlers
+type Sender struct {
+	mailer Mailer
+	ctx    context.Context
+}
+
+func NewSender(mailer Mailer) *Sender {
+	return &Sender{mailer: mailer, ctx: context.Background()}
+}
+
+// Send delivers a rendered email, giving up after Timeout. It takes what a
+// template returned, so a rendering error is passed back as it is.
+func (s *Sender) Send(msg *Message, err error) error {
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, Timeout)
+	defer cancel()
+
+	return s.mailer.Send(ctx, msg)
+}
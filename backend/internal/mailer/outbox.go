@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OutboxMailer writes emails to a local directory as .eml files instead of
+// sending them, for development setups without an SMTP relay
+type OutboxMailer struct {
+	dir  string
+	from string
+}
+
+// NewOutboxMailer creates a mailer writing into dir. With an empty dir the
+// emails are only logged.
+func NewOutboxMailer(dir, from string) *OutboxMailer {
+	return &OutboxMailer{dir: dir, from: from}
+}
+
+func (m *OutboxMailer) Send(ctx context.Context, msg *Message) error {
+	log.Printf("outbox: %q to %s\n%s", msg.Subject, msg.To, msg.Text)
+
+	if m.dir == "" {
+		return nil
+	}
+
+	data, err := buildMIME(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000000000"), recipient)
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0o644)
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+)
+
+// SMTPMailer sends emails through an SMTP relay
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer creates a mailer for the given relay. Authentication is
+// skipped when no user is configured.
+func NewSMTPMailer(host, port, user, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if user != "" {
+		auth = smtp.PlainAuth("", user, password, host)
+	}
+
+	return &SMTPMailer{
+		addr: net.JoinHostPort(host, port),
+		auth: auth,
+		from: from,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	data, err := buildMIME(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, data)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+)
+
+// render builds a message from the <name>.html and <name>.txt templates
+func render(to, subject, name string, data any) (*Message, error) {
+	var html, text bytes.Buffer
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return nil, err
+	}
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		To:      to,
+		Subject: subject,
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
+
+// VerificationEmail asks a newly registered user to confirm their address
+func VerificationEmail(to, firstName, link string) (*Message, error) {
+	return render(to, "Verify your account", "verification", map[string]any{
+		"FirstName": firstName,
+		"Link":      link,
+	})
+}
+
+// OTPEmail delivers a one-time login code
+func OTPEmail(to, firstName, otp string, ttl time.Duration) (*Message, error) {
+	return render(to, "Your login code", "otp", map[string]any{
+		"FirstName": firstName,
+		"OTP":       otp,
+		"Minutes":   int(ttl.Minutes()),
+	})
+}
+
+// InvitationEmail tells someone they have been invited to a room
+func InvitationEmail(to, inviterName, roomName, link string) (*Message, error) {
+	return render(to, "You're invited to "+roomName, "invitation", map[string]any{
+		"InviterName": inviterName,
+		"RoomName":    roomName,
+		"Link":        link,
+	})
+}
+
+// JoinRequestDecisionEmail tells a user whether their join request was approved
+func JoinRequestDecisionEmail(to, roomName string, approved bool, reason, link string) (*Message, error) {
+	subject := "Your request to join " + roomName + " was declined"
+	if approved {
+		subject = "Your request to join " + roomName + " was approved"
+	}
+
+	return render(to, subject, "join_request", map[string]any{
+		"RoomName": roomName,
+		"Approved": approved,
+		"Reason":   reason,
+		"Link":     link,
+	})
+}
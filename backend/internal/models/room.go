@@ -17,13 +17,25 @@ type Room struct {
 	MuteOnEntry      bool   `json:"mute_on_entry" gorm:"default:true"`
 	RequirePassword  bool   `json:"require_password" gorm:"default:false"`
 	Password         string `json:"-" gorm:"default:null"`
+
+	// Set once the last member has left, archived rooms are hidden everywhere
+	ArchivedAt *time.Time `json:"archivedAt,omitempty" gorm:"index"`
 }
 
+// Roles a room member can hold, from most to least privileged
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+	RoleGuest     = "guest"
+)
+
 type RoomMember struct {
 	ID        string    `json:"id" gorm:"primaryKey,index"`
 	RoomID    string    `json:"roomId" gorm:"not null;index:idx_room_user,unique"`
 	UserID    string    `json:"userId" gorm:"not null;index:idx_room_user,unique"`
-	Role      string    `json:"role" gorm:"default:member"`
+	Role      string    `json:"role" gorm:"default:member"` // owner, admin, moderator, member, guest
 	JoinedAt  time.Time `json:"joinedAt"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
@@ -47,6 +59,36 @@ type JoinRequest struct {
 	Message   string    `json:"message"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Set when an admin approves or rejects the request
+	ReviewedBy string     `json:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time `json:"reviewedAt,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+}
+
+// RoomInviteLink is a shareable token that makes whoever redeems it a member
+type RoomInviteLink struct {
+	ID        string     `json:"id" gorm:"primaryKey,index"`
+	RoomID    string     `json:"roomId" gorm:"not null;index"`
+	Token     string     `json:"token" gorm:"not null;uniqueIndex"`
+	Role      string     `json:"role" gorm:"default:member"`
+	MaxUses   int        `json:"maxUses" gorm:"not null;default:0"` // 0 means unlimited
+	Uses      int        `json:"uses" gorm:"not null;default:0"`
+	ExpiresAt time.Time  `json:"expiresAt" gorm:"not null"`
+	CreatedBy string     `json:"createdBy" gorm:"not null"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// RoomBan keeps a user from joining a room again, until ExpiresAt if set
+type RoomBan struct {
+	ID        string     `json:"id" gorm:"primaryKey,index"`
+	RoomID    string     `json:"roomId" gorm:"not null;index:idx_room_ban,unique"`
+	UserID    string     `json:"userId" gorm:"not null;index:idx_room_ban,unique"`
+	Reason    string     `json:"reason"`
+	BannedBy  string     `json:"bannedBy" gorm:"not null"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
 }
 
 type RoomStats struct {
@@ -55,10 +97,45 @@ type RoomStats struct {
 	TotalParticipants int       `json:"totalParticipants" gorm:"not null"`
 	AverageDuration   int       `json:"averageDuration" gorm:"not null"` // In seconds
 	TotalMeetings     int       `json:"totalMeetings" gorm:"not null"`
-	BandwidthUsage    int64     `json:"bandwidthUsage" gorm:"not null"` // In bytes
+	BandwidthUsage    int64     `json:"bandwidthUsage" gorm:"not null"` // In bytes received by participants
 	UpdatedAt         time.Time `json:"updatedAt"`
 }
 
+// MeetingSession is one meeting held in a room, from the first participant
+// joining until the last one left
+type MeetingSession struct {
+	ID                string     `json:"id" gorm:"primaryKey,index"`
+	RoomID            string     `json:"roomId" gorm:"not null;index:idx_room_started"`
+	StartedAt         time.Time  `json:"startedAt" gorm:"not null;index:idx_room_started"`
+	EndedAt           *time.Time `json:"endedAt"`
+	Duration          int        `json:"duration"`          // In seconds, set once ended
+	ParticipantsCount int        `json:"participantsCount"` // Distinct users, set once ended
+	BandwidthUsage    int64      `json:"bandwidthUsage"`    // In bytes received by participants, set once ended
+}
+
+// SessionParticipant is one stretch of time a user spent in a meeting.
+// Reconnects within the grace period don't start a new one, they are
+// counted in Reconnects.
+type SessionParticipant struct {
+	ID          string     `json:"id" gorm:"primaryKey,index"`
+	SessionID   string     `json:"sessionId" gorm:"not null;index"`
+	RoomID      string     `json:"roomId" gorm:"not null;index"`
+	UserID      string     `json:"userId" gorm:"not null;index"`
+	DisplayName string     `json:"displayName"`
+	IsGuest     bool       `json:"isGuest"`
+	JoinedAt    time.Time  `json:"joinedAt" gorm:"not null"`
+	LeftAt      *time.Time `json:"leftAt"`
+	Reconnects  int        `json:"reconnects" gorm:"default:0"`
+
+	// Call quality, summed up from the participant's stats reports
+	BytesTransferred int64   `json:"bytesTransferred" gorm:"default:0"` // Received only, so a byte sent from one participant to another counts once
+	StatsReports     int     `json:"statsReports" gorm:"default:0"`
+	AvgRTT           float64 `json:"avgRtt" gorm:"default:0"`    // In milliseconds
+	AvgJitter        float64 `json:"avgJitter" gorm:"default:0"` // In milliseconds
+	AvgPacketLoss    float64 `json:"avgPacketLoss" gorm:"default:0"`
+	AvgQualityScore  float64 `json:"avgQualityScore" gorm:"default:0"`
+}
+
 // type Message struct {
 // 	ID        string    `json:"id" gorm:"primaryKey,index"`
 // 	RoomID    string    `json:"roomId" gorm:"not null;index:idx_room_user"`
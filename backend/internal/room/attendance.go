@@ -0,0 +1,178 @@
+package room
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// attendanceRecord is one user's attendance of a meeting, all their
+// stretches in it taken together
+type attendanceRecord struct {
+	UserID        string     `json:"userId"`
+	Name          string     `json:"name"`
+	Username      string     `json:"username"`
+	IsGuest       bool       `json:"isGuest"`
+	FirstJoinedAt time.Time  `json:"firstJoinedAt"`
+	LastLeftAt    *time.Time `json:"lastLeftAt"` // nil while still in the meeting
+	TotalSeconds  int        `json:"totalSeconds"`
+	Reconnects    int        `json:"reconnects"`
+}
+
+// attendanceOf merges a meeting's participant stretches, ordered by when
+// they joined, per user. Coming back to the meeting, within the grace period
+// or after it, counts as a reconnect. Stretches still open count up to now,
+// and time covered by overlapping stretches is only counted once.
+func attendanceOf(participants []sessionParticipantInfo, now time.Time) []attendanceRecord {
+	records := []attendanceRecord{}
+	byUser := make(map[string]int)
+
+	// Per user, the end of the time counted so far
+	counted := make(map[string]time.Time)
+
+	for _, p := range participants {
+		leftAt := now
+		if p.LeftAt != nil {
+			leftAt = *p.LeftAt
+		}
+		from := p.JoinedAt
+		if until, ok := counted[p.UserID]; ok && until.After(from) {
+			from = until
+		}
+		seconds := 0
+		if leftAt.After(from) {
+			seconds = int(leftAt.Sub(from).Seconds())
+			counted[p.UserID] = leftAt
+		}
+
+		i, seen := byUser[p.UserID]
+		if !seen {
+			name := strings.TrimSpace(p.FirstName + " " + p.LastName)
+			if name == "" {
+				name = p.DisplayName
+			}
+
+			byUser[p.UserID] = len(records)
+			records = append(records, attendanceRecord{
+				UserID:        p.UserID,
+				Name:          name,
+				Username:      p.Username,
+				IsGuest:       p.IsGuest,
+				FirstJoinedAt: p.JoinedAt,
+				LastLeftAt:    p.LeftAt,
+				TotalSeconds:  seconds,
+				Reconnects:    p.Reconnects,
+			})
+			continue
+		}
+
+		record := &records[i]
+		if p.JoinedAt.Before(record.FirstJoinedAt) {
+			record.FirstJoinedAt = p.JoinedAt
+		}
+		if p.LeftAt == nil || (record.LastLeftAt != nil && p.LeftAt.After(*record.LastLeftAt)) {
+			record.LastLeftAt = p.LeftAt
+		}
+		record.TotalSeconds += seconds
+		record.Reconnects += p.Reconnects + 1
+	}
+
+	return records
+}
+
+// GetMeetingAttendance returns who attended the meeting and for how long
+func (s *RoomService) GetMeetingAttendance(roomId, sessionId string) ([]attendanceRecord, error) {
+	_, participants, err := s.GetMeetingSession(roomId, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	return attendanceOf(participants, time.Now()), nil
+}
+
+var attendanceCSVHeader = []string{
+	"user_id", "name", "username", "guest", "first_joined_at", "last_left_at", "total_seconds", "reconnects",
+}
+
+// csvSafe keeps user supplied names from being read as formulas by
+// spreadsheet apps
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func writeAttendanceCSV(w *csv.Writer, records []attendanceRecord) error {
+	if err := w.Write(attendanceCSVHeader); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		lastLeft := ""
+		if record.LastLeftAt != nil {
+			lastLeft = record.LastLeftAt.UTC().Format(time.RFC3339)
+		}
+
+		if err := w.Write([]string{
+			record.UserID,
+			csvSafe(record.Name),
+			csvSafe(record.Username),
+			strconv.FormatBool(record.IsGuest),
+			record.FirstJoinedAt.UTC().Format(time.RFC3339),
+			lastLeft,
+			strconv.Itoa(record.TotalSeconds),
+			strconv.Itoa(record.Reconnects),
+		}); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// ExportAttendance exports a meeting's attendance as JSON, or as a CSV
+// download with ?format=csv
+func (r *RoomHander) ExportAttendance(ctx *gin.Context) {
+	roomId := ctx.Param("roomId")
+	sessionId := ctx.Param("sessionId")
+
+	format := ctx.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	records, err := r.server.GetMeetingAttendance(roomId, sessionId)
+	if errors.Is(err, ErrMeetingSessionNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if format == "json" {
+		ctx.JSON(http.StatusOK, gin.H{
+			"message":    "Fetched attendance successfully",
+			"sessionId":  sessionId,
+			"attendance": records,
+		})
+		return
+	}
+
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="attendance-%s.csv"`, sessionId))
+	ctx.Status(http.StatusOK)
+	if err := writeAttendanceCSV(csv.NewWriter(ctx.Writer), records); err != nil {
+		fmt.Printf("Failed to write attendance of session %s: %v\n", sessionId, err)
+	}
+}
@@ -0,0 +1,198 @@
+package room
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+	"video-chat/internal/models"
+)
+
+func stretch(userID string, joinedAt time.Time, leftAt *time.Time, reconnects int) sessionParticipantInfo {
+	return sessionParticipantInfo{
+		SessionParticipant: models.SessionParticipant{
+			UserID:     userID,
+			JoinedAt:   joinedAt,
+			LeftAt:     leftAt,
+			Reconnects: reconnects,
+		},
+		FirstName: "Ada",
+		LastName:  "Lovelace",
+		Username:  userID,
+	}
+}
+
+func TestAttendanceOf(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	left := func(minutes int) *time.Time { t := at(minutes); return &t }
+	now := at(60)
+
+	tests := []struct {
+		name           string
+		participants   []sessionParticipantInfo
+		wantSeconds    int
+		wantReconnects int
+		wantFirst      time.Time
+		wantLast       *time.Time
+	}{
+		{
+			name:         "single stretch",
+			participants: []sessionParticipantInfo{stretch("u1", at(0), left(10), 0)},
+			wantSeconds:  600,
+			wantFirst:    at(0),
+			wantLast:     left(10),
+		},
+		{
+			name: "disjoint stretches",
+			participants: []sessionParticipantInfo{
+				stretch("u1", at(0), left(10), 0),
+				stretch("u1", at(20), left(25), 0),
+			},
+			wantSeconds:    900,
+			wantReconnects: 1,
+			wantFirst:      at(0),
+			wantLast:       left(25),
+		},
+		{
+			name: "overlapping stretches",
+			participants: []sessionParticipantInfo{
+				stretch("u1", at(0), left(10), 0),
+				stretch("u1", at(5), left(15), 0),
+				stretch("u1", at(6), left(8), 0),
+			},
+			wantSeconds:    900,
+			wantReconnects: 2,
+			wantFirst:      at(0),
+			wantLast:       left(15),
+		},
+		{
+			name: "still open",
+			participants: []sessionParticipantInfo{
+				stretch("u1", at(0), left(10), 0),
+				stretch("u1", at(30), nil, 0),
+			},
+			wantSeconds:    600 + 1800,
+			wantReconnects: 1,
+			wantFirst:      at(0),
+			wantLast:       nil,
+		},
+		{
+			name: "rejoins within the grace period",
+			participants: []sessionParticipantInfo{
+				stretch("u1", at(0), left(10), 2),
+				stretch("u1", at(12), left(20), 1),
+			},
+			wantSeconds:    1080,
+			wantReconnects: 2 + 1 + 1,
+			wantFirst:      at(0),
+			wantLast:       left(20),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := attendanceOf(tt.participants, now)
+			if len(records) != 1 {
+				t.Fatalf("got %d records, want 1", len(records))
+			}
+			record := records[0]
+
+			if record.Name != "Ada Lovelace" {
+				t.Errorf("name = %q, want %q", record.Name, "Ada Lovelace")
+			}
+			if record.TotalSeconds != tt.wantSeconds {
+				t.Errorf("total seconds = %d, want %d", record.TotalSeconds, tt.wantSeconds)
+			}
+			if record.Reconnects != tt.wantReconnects {
+				t.Errorf("reconnects = %d, want %d", record.Reconnects, tt.wantReconnects)
+			}
+			if !record.FirstJoinedAt.Equal(tt.wantFirst) {
+				t.Errorf("first joined at %v, want %v", record.FirstJoinedAt, tt.wantFirst)
+			}
+			switch {
+			case tt.wantLast == nil && record.LastLeftAt != nil:
+				t.Errorf("last left at %v, want still in the meeting", *record.LastLeftAt)
+			case tt.wantLast != nil && (record.LastLeftAt == nil || !record.LastLeftAt.Equal(*tt.wantLast)):
+				t.Errorf("last left at %v, want %v", record.LastLeftAt, *tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestAttendanceOfSeveralUsers(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	leftAt := start.Add(time.Minute)
+
+	guest := stretch("guest-1", start.Add(time.Second), &leftAt, 0)
+	guest.FirstName, guest.LastName = "", ""
+	guest.DisplayName = "Visitor"
+	guest.IsGuest = true
+
+	records := attendanceOf([]sessionParticipantInfo{
+		stretch("u1", start, &leftAt, 0),
+		guest,
+		stretch("u1", start.Add(2*time.Minute), nil, 0),
+	}, start.Add(3*time.Minute))
+
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	if records[0].UserID != "u1" || records[1].UserID != "guest-1" {
+		t.Fatalf("records are ordered %s, %s, want by first join", records[0].UserID, records[1].UserID)
+	}
+	if records[0].TotalSeconds != 120 {
+		t.Errorf("u1 attended %d seconds, want 120", records[0].TotalSeconds)
+	}
+	if records[1].Name != "Visitor" || !records[1].IsGuest {
+		t.Errorf("guest record is %+v, want the display name and the guest flag", records[1])
+	}
+}
+
+func TestCSVSafe(t *testing.T) {
+	tests := map[string]string{
+		"":                  "",
+		"Ada Lovelace":      "Ada Lovelace",
+		"=HYPERLINK(\"x\")": "'=HYPERLINK(\"x\")",
+		"+1 555":            "'+1 555",
+		"-2+3":              "'-2+3",
+		"@SUM(A1)":          "'@SUM(A1)",
+		"a=b":               "a=b",
+	}
+
+	for value, want := range tests {
+		if got := csvSafe(value); got != want {
+			t.Errorf("csvSafe(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestWriteAttendanceCSV(t *testing.T) {
+	joinedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	err := writeAttendanceCSV(csv.NewWriter(&buf), []attendanceRecord{{
+		UserID:        "u1",
+		Name:          "=cmd|' /C calc'!A0",
+		Username:      "@ada",
+		FirstJoinedAt: joinedAt,
+		TotalSeconds:  42,
+		Reconnects:    1,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want the header and one record", len(rows))
+	}
+	want := []string{"u1", "'=cmd|' /C calc'!A0", "'@ada", "false", "2024-05-01T10:00:00Z", "", "42", "1"}
+	for i := range want {
+		if rows[1][i] != want[i] {
+			t.Errorf("column %s = %q, want %q", attendanceCSVHeader[i], rows[1][i], want[i])
+		}
+	}
+}
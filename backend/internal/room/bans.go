@@ -0,0 +1,200 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"video-chat/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUserBanned     = errors.New("you are banned from this room")
+	ErrBanNotFound    = errors.New("ban not found")
+	ErrCannotBanStaff = errors.New("you can only ban members below your own role")
+)
+
+// BanUser bans a user from the room, replacing an earlier ban. The user
+// loses their membership and pending join request in the same transaction.
+func (s *RoomService) BanUser(roomId, userId, bannedBy, reason string, expiresAt *time.Time) (*models.RoomBan, error) {
+	ban := &models.RoomBan{
+		ID:        uuid.NewString(),
+		RoomID:    roomId,
+		UserID:    userId,
+		Reason:    reason,
+		BannedBy:  bannedBy,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "room_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"id", "reason", "banned_by", "expires_at", "created_at"}),
+		}).Create(ban).Error; err != nil {
+			return err
+		}
+
+		if _, err := removeRoomMember(tx, roomId, userId); err != nil {
+			return err
+		}
+
+		return tx.Where("room_id = ? AND user_id = ? AND status = ?", roomId, userId, "pending").
+			Delete(&models.JoinRequest{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ban, nil
+}
+
+// CheckBan returns ErrUserBanned while the user has a ban in effect
+func (s *RoomService) CheckBan(roomId, userId string) error {
+	var count int64
+	if err := s.db.Model(&models.RoomBan{}).
+		Where("room_id = ? AND user_id = ? AND (expires_at IS NULL OR expires_at > ?)", roomId, userId, time.Now()).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrUserBanned
+	}
+	return nil
+}
+
+// rejectBanned responds with 403 and returns true when the user is banned
+func (r *RoomHander) rejectBanned(ctx *gin.Context, roomId, userId string) bool {
+	err := r.server.CheckBan(roomId, userId)
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrUserBanned) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	} else {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	return true
+}
+
+type banInfo struct {
+	models.RoomBan
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Email     string `json:"email"`
+	Username  string `json:"username"`
+}
+
+// ListBans returns the bans in effect with the banned user's details
+func (s *RoomService) ListBans(roomId string) ([]banInfo, error) {
+	bans := []banInfo{}
+	if err := s.db.Model(&models.RoomBan{}).
+		Select("room_bans.*, users.first_name, users.last_name, users.email, users.username").
+		Joins("LEFT JOIN users ON users.id = room_bans.user_id").
+		Where("room_bans.room_id = ? AND (room_bans.expires_at IS NULL OR room_bans.expires_at > ?)", roomId, time.Now()).
+		Order("room_bans.created_at DESC").
+		Scan(&bans).Error; err != nil {
+		return nil, err
+	}
+
+	return bans, nil
+}
+
+func (s *RoomService) LiftBan(roomId, banId string) error {
+	result := s.db.Where("id = ? AND room_id = ?", banId, roomId).Delete(&models.RoomBan{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrBanNotFound
+	}
+	return nil
+}
+
+type banUserRequest struct {
+	UserID string `json:"userId" binding:"required"`
+	Reason string `json:"reason" binding:"max=500"`
+	// ExpiresIn is the ban length in seconds, permanent when left out
+	ExpiresIn int `json:"expiresIn" binding:"min=0"`
+}
+
+func (r *RoomHander) BanUser(ctx *gin.Context) {
+	roomId := ctx.Param("roomId")
+	userId := ctx.GetString("userId")
+
+	var req banUserRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.UserID == userId {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "You can't ban yourself"})
+		return
+	}
+
+	outranks, err := r.server.OutranksMember(roomId, userId, req.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !outranks {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": ErrCannotBanStaff.Error()})
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresIn > 0 {
+		expiry := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		expiresAt = &expiry
+	}
+
+	ban, err := r.server.BanUser(roomId, req.UserID, userId, req.Reason, expiresAt)
+	if err != nil {
+		fmt.Printf("Failed to ban user %s from room %s: %v\n", req.UserID, roomId, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ban user"})
+		return
+	}
+
+	// Out of the live meeting too
+	r.hub.Kick(roomId, req.UserID, req.Reason, true)
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "User banned successfully",
+		"ban":     ban,
+	})
+}
+
+func (r *RoomHander) ListBans(ctx *gin.Context) {
+	bans, err := r.server.ListBans(ctx.Param("roomId"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Fetched bans successfully",
+		"bans":    bans,
+	})
+}
+
+func (r *RoomHander) LiftBan(ctx *gin.Context) {
+	err := r.server.LiftBan(ctx.Param("roomId"), ctx.Param("banId"))
+	if errors.Is(err, ErrBanNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Ban lifted successfully"})
+}
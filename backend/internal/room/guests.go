@@ -0,0 +1,262 @@
+package room
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"video-chat/internal/models"
+	"video-chat/internal/utils"
+	"video-chat/internal/websockets"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrInvalidGuestToken = errors.New("invalid or expired guest token")
+
+const (
+	guestTokenBytes    = 24
+	defaultGuestTTL    = 4 * time.Hour
+	maxGuestTTL        = 24 * time.Hour
+	guestTokenPrefix   = "guest-"
+	roomGuestsPrefix   = "room-guests-"
+	guestIDPrefix      = "guest-"
+	maxGuestNameLength = 50
+)
+
+// guestPass is what a guest token grants, stored in Redis under the token
+type guestPass struct {
+	GuestID     string    `json:"guestId"`
+	RoomID      string    `json:"roomId"`
+	DisplayName string    `json:"displayName"`
+	AllowChat   bool      `json:"allowChat"`
+	IssuedBy    string    `json:"issuedBy"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+func guestTokenKey(token string) string {
+	return guestTokenPrefix + token
+}
+
+func roomGuestsKey(roomId string) string {
+	return roomGuestsPrefix + roomId
+}
+
+func (r *RoomHander) getGuestPass(token string) (*guestPass, error) {
+	raw, err := r.redisClient.Get(r.ctx, guestTokenKey(token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidGuestToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pass guestPass
+	if err := json.Unmarshal([]byte(raw), &pass); err != nil {
+		return nil, err
+	}
+	return &pass, nil
+}
+
+// expireGuests drops every guest token of a room. Registered with the hub,
+// it runs once the last participant has left the meeting.
+func (r *RoomHander) expireGuests(roomId string) {
+	tokens, err := r.redisClient.SMembers(r.ctx, roomGuestsKey(roomId)).Result()
+	if err != nil {
+		log.Printf("error reading guest tokens of room %s: %v", roomId, err)
+		return
+	}
+	if len(tokens) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(tokens)+1)
+	for _, token := range tokens {
+		keys = append(keys, guestTokenKey(token))
+	}
+	keys = append(keys, roomGuestsKey(roomId))
+
+	if err := r.redisClient.Del(r.ctx, keys...).Err(); err != nil {
+		log.Printf("error expiring guest tokens of room %s: %v", roomId, err)
+	}
+}
+
+// revokeGuest drops the tokens of a guest removed from the room. Registered
+// with the hub, it runs for every kick and ban and ignores members.
+func (r *RoomHander) revokeGuest(roomId, userId string) {
+	if !strings.HasPrefix(userId, guestIDPrefix) {
+		return
+	}
+
+	tokens, err := r.redisClient.SMembers(r.ctx, roomGuestsKey(roomId)).Result()
+	if err != nil {
+		log.Printf("error reading guest tokens of room %s: %v", roomId, err)
+		return
+	}
+
+	for _, token := range tokens {
+		pass, err := r.getGuestPass(token)
+		if errors.Is(err, ErrInvalidGuestToken) {
+			r.redisClient.SRem(r.ctx, roomGuestsKey(roomId), token)
+			continue
+		}
+		if err != nil {
+			log.Printf("error reading guest token of room %s: %v", roomId, err)
+			continue
+		}
+		if pass.GuestID != userId {
+			continue
+		}
+
+		pipe := r.redisClient.TxPipeline()
+		pipe.Del(r.ctx, guestTokenKey(token))
+		pipe.SRem(r.ctx, roomGuestsKey(roomId), token)
+		if _, err := pipe.Exec(r.ctx); err != nil {
+			log.Printf("error revoking guest %s of room %s: %v", userId, roomId, err)
+		}
+	}
+}
+
+type issueGuestTokenRequest struct {
+	DisplayName string `json:"displayName" binding:"required"`
+	AllowChat   bool   `json:"allowChat"`
+	// ExpiresIn is the lifetime in seconds, four hours when left out
+	ExpiresIn int `json:"expiresIn" binding:"min=0"`
+}
+
+func (r *RoomHander) IssueGuestToken(ctx *gin.Context) {
+	roomId := ctx.Param("roomId")
+	userId := ctx.GetString("userId")
+
+	var req issueGuestTokenRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.DisplayName) > maxGuestNameLength {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Display name is too long"})
+		return
+	}
+
+	ttl := defaultGuestTTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl > maxGuestTTL {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Guest tokens can be valid for 24 hours at most"})
+		return
+	}
+
+	pass := guestPass{
+		GuestID:     guestIDPrefix + uuid.NewString(),
+		RoomID:      roomId,
+		DisplayName: req.DisplayName,
+		AllowChat:   req.AllowChat,
+		IssuedBy:    userId,
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+	data, _ := json.Marshal(pass)
+	token := utils.GenerateToken(guestTokenBytes)
+
+	pipe := r.redisClient.TxPipeline()
+	pipe.Set(r.ctx, guestTokenKey(token), data, ttl)
+	pipe.SAdd(r.ctx, roomGuestsKey(roomId), token)
+	// Outlives every token in it, the tokens themselves expire on their own
+	pipe.Expire(r.ctx, roomGuestsKey(roomId), maxGuestTTL)
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		fmt.Printf("Failed to store guest token: %v\n", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue guest token"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Guest token issued successfully",
+		"token":   token,
+		"guest":   pass,
+		"url":     fmt.Sprintf("%s/guest/%s", utils.GetEnvOrDefaultValue("UI_HOST", "localhost:3000"), token),
+	})
+}
+
+// ServeGuestWebsocket joins a guest to the one room their token was issued
+// for. Guests skip the membership and password checks, but not bans.
+func (r *RoomHander) ServeGuestWebsocket(ctx *gin.Context) {
+	conn, err := websockets.Upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		log.Printf("error upgrading to websocket: %v", err)
+		return
+	}
+
+	pass, err := r.getGuestPass(ctx.Param("token"))
+	if errors.Is(err, ErrInvalidGuestToken) {
+		websockets.RejectConnection(conn, websockets.CloseForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("error reading guest token: %v", err)
+		websockets.RejectConnection(conn, websockets.CloseInternalError, "unable to join room")
+		return
+	}
+
+	room, err := r.server.GetRoom(pass.RoomID)
+	if errors.Is(err, ErrRoomNotFound) {
+		websockets.RejectConnection(conn, websockets.CloseRoomNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("error authorizing guest for room %s: %v", pass.RoomID, err)
+		websockets.RejectConnection(conn, websockets.CloseInternalError, "unable to join room")
+		return
+	}
+
+	if r.meetingLocked(room.ID, pass.GuestID) {
+		websockets.RejectConnection(conn, websockets.CloseRoomLocked, "meeting is locked")
+		return
+	}
+
+	err = r.server.CheckBan(room.ID, pass.GuestID)
+	if errors.Is(err, ErrUserBanned) {
+		websockets.RejectConnection(conn, websockets.CloseForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("error checking bans of guest in room %s: %v", room.ID, err)
+		websockets.RejectConnection(conn, websockets.CloseInternalError, "unable to join room")
+		return
+	}
+
+	client := websockets.NewClient(r.hub, conn, room.ID, pass.GuestID, pass.DisplayName)
+	client.AsGuest(pass.AllowChat)
+	client.SetRole(models.RoleGuest)
+	r.startSession(ctx, conn, room, pass.GuestID, client)
+}
+
+// GetGuestPass shows a guest which room their token is for
+func (r *RoomHander) GetGuestPass(ctx *gin.Context) {
+	pass, err := r.getGuestPass(ctx.Param("token"))
+	if errors.Is(err, ErrInvalidGuestToken) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	room, err := r.server.GetRoom(pass.RoomID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Fetched guest token successfully",
+		"guest":   pass,
+		"room":    gin.H{"id": room.ID, "name": room.Name, "description": room.Description},
+	})
+}
@@ -7,10 +7,12 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+	"video-chat/internal/mailer"
 	"video-chat/internal/models"
+	"video-chat/internal/utils"
+	"video-chat/internal/websockets"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
@@ -18,11 +20,20 @@ import (
 type RoomHander struct {
 	server      *RoomService
 	redisClient *redis.Client
+	hub         *websockets.Hub
+	mailer      *mailer.Sender
 	ctx         context.Context
 }
 
-func NewRoomHandler(server *RoomService, redisClient *redis.Client) *RoomHander {
-	return &RoomHander{server: server, redisClient: redisClient, ctx: context.Background()}
+func NewRoomHandler(server *RoomService, redisClient *redis.Client, hub *websockets.Hub, mail mailer.Mailer) *RoomHander {
+	handler := &RoomHander{server: server, redisClient: redisClient, hub: hub, mailer: mailer.NewSender(mail), ctx: context.Background()}
+
+	// Guest tokens only last as long as the meeting
+	hub.OnMeetingEnded(handler.expireGuests)
+	// and a removed guest can't come back with theirs
+	hub.OnKicked(handler.revokeGuest)
+
+	return handler
 }
 
 type CreateRoomRequest struct {
@@ -47,6 +58,11 @@ func (r *RoomHander) CreateRoom(ctx *gin.Context) {
 		return
 	}
 
+	if req.IsPasswordProtected && (len(req.Password) < 4 || len(req.Password) > 72) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Password must be between 4 and 72 characters"})
+		return
+	}
+
 	userId := ctx.GetString("userId")
 
 	// Create room and also add invited users in case of private
@@ -57,11 +73,9 @@ func (r *RoomHander) CreateRoom(ctx *gin.Context) {
 	}
 
 	if len(req.InvitedUsers) > 0 {
-		for _, email := range req.InvitedUsers {
-			if err := r.server.InviteUserByEmail(room.ID, email, userId); err != nil {
-				ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
+		if err := r.inviteUsers(room, userId, req.InvitedUsers); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
 	}
 
@@ -71,6 +85,57 @@ func (r *RoomHander) CreateRoom(ctx *gin.Context) {
 	})
 }
 
+// inviteUsers stores an invite for every email and lets the invitees know
+func (r *RoomHander) inviteUsers(room *models.Room, userId string, emails []string) error {
+	inviterName := ""
+	var inviter models.User
+	if err := r.server.db.Where("id = ?", userId).First(&inviter).Error; err == nil {
+		inviterName = inviter.FirstName
+	}
+	link := fmt.Sprintf("%s/room/list", utils.GetEnvOrDefaultValue("UI_HOST", "localhost:3000"))
+
+	for _, email := range emails {
+		if err := r.server.InviteUserByEmail(room.ID, email, userId); err != nil {
+			return err
+		}
+
+		// The invite is stored either way, it also shows up in the room list
+		if err := r.mailer.Send(mailer.InvitationEmail(email, inviterName, room.Name, link)); err != nil {
+			fmt.Printf("Failed to send invitation to %s: %v\n", email, err)
+		}
+	}
+
+	return nil
+}
+
+type inviteUsersRequest struct {
+	Emails []string `json:"emails" binding:"required,min=1,dive,email"`
+}
+
+func (r *RoomHander) InviteUsers(ctx *gin.Context) {
+	roomId := ctx.Param("roomId")
+	userId := ctx.GetString("userId")
+
+	var req inviteUsersRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	room, err := r.server.getRoomDetails(roomId)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if err := r.inviteUsers(room, userId, req.Emails); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Invites sent successfully"})
+}
+
 func (r *RoomHander) DeleteRoom(ctx *gin.Context) {
 	roomId := ctx.Param("roomId")
 
@@ -252,6 +317,10 @@ func (r *RoomHander) AcceptRoomInvite(ctx *gin.Context) {
 	roomId := ctx.Param("roomId")
 	userId := ctx.GetString("userId")
 
+	if r.rejectBanned(ctx, roomId, userId) {
+		return
+	}
+
 	var user *models.User
 	if err := r.server.db.Where("id = ?", userId).First(&user).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user details"})
@@ -325,6 +394,10 @@ func (r *RoomHander) RequestToJoin(ctx *gin.Context) {
 		return
 	}
 
+	if r.rejectBanned(ctx, roomId, userId) {
+		return
+	}
+
 	joineeRequest, err := r.server.GetJoinRequest(userId, roomId)
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -358,18 +431,20 @@ func (r *RoomHander) LeaveRoom(ctx *gin.Context) {
 	roomId := ctx.Param("roomId")
 	userId := ctx.GetString("userId")
 
-	roomMember, err := r.server.GetRoomMember(userId, roomId)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	result, err := r.server.LeaveRoom(roomId, userId)
+	if errors.Is(err, ErrNotRoomMember) || errors.Is(err, ErrRoomNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
-
-	if err := r.server.DeleteRoomMember(roomMember); err != nil {
+	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"message": "Left room successfully"})
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Left room successfully",
+		"room":    result,
+	})
 }
 
 func (r *RoomHander) GetJoinRequest(ctx *gin.Context) {
@@ -461,35 +536,13 @@ func (r *RoomHander) SendMessage(ctx *gin.Context) {
 		return
 	}
 
-	if len(req.Content) > 2500 {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Message too long"})
-		return
-	}
-
-	room, err := r.server.getRoomDetails(roomId)
+	message, err := r.server.CreateMessage(roomId, userId, req.Content)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(messageErrorStatus(err), gin.H{"error": messageErrorText(err, "Unable to send message")})
 		return
 	}
 
-	roomMember, err := r.server.GetRoomMember(userId, roomId)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	message := &models.Message{
-		ID:        uuid.NewString(),
-		RoomID:    room.ID,
-		UserID:    roomMember.UserID,
-		Content:   req.Content,
-		CreatedAt: time.Now(),
-	}
-
-	if err := r.server.addMessage(message); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to send message"})
-		return
-	}
+	r.hub.PublishChatEvent(websockets.TypeMessage, message)
 
 	ctx.JSON(http.StatusCreated, gin.H{
 		"message": "Message sent",
@@ -563,38 +616,13 @@ func (r *RoomHander) EditMessage(ctx *gin.Context) {
 		return
 	}
 
-	if len(req.Content) > 2500 {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Message too long"})
-		return
-	}
-
-	message, err := r.server.getMessageById(messageId)
+	message, err := r.server.UpdateMessage(roomId, messageId, userId, req.Content)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Message Not found"})
+		ctx.JSON(messageErrorStatus(err), gin.H{"error": messageErrorText(err, "Failed to update message")})
 		return
 	}
 
-	if message.UserID != userId {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "You can only edit your own messages"})
-		return
-	}
-
-	if message.RoomID != roomId {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "You can only edit your own messages"})
-		return
-	}
-
-	if time.Since(message.CreatedAt) > time.Hour {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "Edit window expired"})
-		return
-	}
-
-	message.Content = req.Content
-	message.UpdatedAt = time.Now()
-	if err := r.server.editMessage(message); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update message"})
-		return
-	}
+	r.hub.PublishChatEvent(websockets.TypeMessageEdited, message)
 
 	ctx.JSON(http.StatusOK, message)
 }
@@ -604,31 +632,36 @@ func (r *RoomHander) DeleteMessage(ctx *gin.Context) {
 	messageId := ctx.Param("messageId")
 	userId := ctx.GetString("userId")
 
-	message, err := r.server.getMessageById(messageId)
+	message, err := r.server.RemoveMessage(roomId, messageId, userId)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Message Not found"})
+		ctx.JSON(messageErrorStatus(err), gin.H{"error": messageErrorText(err, "Failed to delete message")})
 		return
 	}
 
-	if message.UserID != userId {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "You can only edit your own messages"})
-		return
-	}
+	r.hub.PublishChatEvent(websockets.TypeMessageDeleted, message)
 
-	if message.RoomID != roomId {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own messages"})
-		return
-	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Message deleted"})
+}
 
-	if time.Since(message.CreatedAt) > time.Hour {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "delete window expired"})
-		return
+// messageErrorStatus maps message validation errors to HTTP status codes
+func messageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrEmptyMessage), errors.Is(err, ErrMessageTooLong):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrChatDisabled), errors.Is(err, ErrNotRoomMember),
+		errors.Is(err, ErrNotMessageOwner), errors.Is(err, ErrEditWindowExpired):
+		return http.StatusForbidden
+	case errors.Is(err, ErrRoomNotFound), errors.Is(err, ErrMessageNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
 	}
+}
 
-	if err := r.server.deleteMessage(message); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
-		return
+// messageErrorText hides unexpected errors behind a generic message
+func messageErrorText(err error, fallback string) string {
+	if messageErrorStatus(err) == http.StatusInternalServerError {
+		return fallback
 	}
-
-	ctx.JSON(http.StatusOK, gin.H{"message": "Message deleted"})
+	return err.Error()
 }
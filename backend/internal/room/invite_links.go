@@ -0,0 +1,291 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"video-chat/internal/models"
+	"video-chat/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInviteLinkNotFound = errors.New("invite link not found")
+	ErrInviteLinkExpired  = errors.New("invite link has expired")
+	ErrInviteLinkRevoked  = errors.New("invite link has been revoked")
+	ErrInviteLinkUsedUp   = errors.New("invite link has reached its maximum uses")
+)
+
+const (
+	inviteTokenBytes      = 24
+	defaultInviteLinkTTL  = 7 * 24 * time.Hour
+	maxInviteLinkLifetime = 30 * 24 * time.Hour
+)
+
+// inviteLinkRoles are the roles an invite link may grant. Admins are only
+// ever appointed by hand.
+var inviteLinkRoles = map[string]bool{
+	models.RoleMember:    true,
+	models.RoleModerator: true,
+}
+
+func (s *RoomService) CreateInviteLink(roomId, userId, role string, maxUses int, expiresAt time.Time) (*models.RoomInviteLink, error) {
+	link := &models.RoomInviteLink{
+		ID:        uuid.NewString(),
+		RoomID:    roomId,
+		Token:     utils.GenerateToken(inviteTokenBytes),
+		Role:      role,
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt,
+		CreatedBy: userId,
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.db.Create(link).Error; err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+func (s *RoomService) ListInviteLinks(roomId string) ([]models.RoomInviteLink, error) {
+	links := []models.RoomInviteLink{}
+	if err := s.db.Where("room_id = ?", roomId).Order("created_at DESC").Find(&links).Error; err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+func (s *RoomService) RevokeInviteLink(roomId, linkId string) (*models.RoomInviteLink, error) {
+	var link models.RoomInviteLink
+	if err := s.db.Where("id = ? AND room_id = ?", linkId, roomId).First(&link).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInviteLinkNotFound
+		}
+		return nil, err
+	}
+
+	if link.RevokedAt == nil {
+		now := time.Now()
+		link.RevokedAt = &now
+		if err := s.db.Save(&link).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &link, nil
+}
+
+// checkInviteLink reports why a link can no longer be redeemed, if it can't
+func checkInviteLink(link *models.RoomInviteLink) error {
+	switch {
+	case link.RevokedAt != nil:
+		return ErrInviteLinkRevoked
+	case time.Now().After(link.ExpiresAt):
+		return ErrInviteLinkExpired
+	case link.MaxUses > 0 && link.Uses >= link.MaxUses:
+		return ErrInviteLinkUsedUp
+	}
+	return nil
+}
+
+// GetInviteLink looks up a token that can still be redeemed
+func (s *RoomService) GetInviteLink(token string) (*models.RoomInviteLink, error) {
+	var link models.RoomInviteLink
+	if err := s.db.Where("token = ?", token).First(&link).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInviteLinkNotFound
+		}
+		return nil, err
+	}
+
+	if err := checkInviteLink(&link); err != nil {
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+// RedeemInviteLink makes the caller a member with the link's role, skipping
+// the join request. A use is only counted when a membership is created.
+func (s *RoomService) RedeemInviteLink(token, userId string) (*models.RoomInviteLink, bool, error) {
+	var link models.RoomInviteLink
+	var added bool
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token = ?", token).First(&link).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInviteLinkNotFound
+			}
+			return err
+		}
+
+		if err := checkInviteLink(&link); err != nil {
+			return err
+		}
+
+		if err := s.CheckBan(link.RoomID, userId); err != nil {
+			return err
+		}
+
+		var err error
+		added, err = addRoomMember(tx, link.RoomID, userId, link.Role)
+		if err != nil || !added {
+			return err
+		}
+
+		// A pending request is moot now
+		if err := tx.Where("room_id = ? AND user_id = ? AND status = ?", link.RoomID, userId, "pending").
+			Delete(&models.JoinRequest{}).Error; err != nil {
+			return err
+		}
+
+		link.Uses++
+		return tx.Model(&link).Update("uses", gorm.Expr("uses + ?", 1)).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &link, added, nil
+}
+
+func inviteLinkURL(token string) string {
+	return fmt.Sprintf("%s/invite/%s", utils.GetEnvOrDefaultValue("UI_HOST", "localhost:3000"), token)
+}
+
+func inviteLinkErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInviteLinkNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInviteLinkExpired), errors.Is(err, ErrInviteLinkRevoked), errors.Is(err, ErrInviteLinkUsedUp):
+		return http.StatusGone
+	case errors.Is(err, ErrUserBanned):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type createInviteLinkRequest struct {
+	Role string `json:"role"`
+	// MaxUses of 0 leaves the link unlimited
+	MaxUses int `json:"maxUses" binding:"min=0,max=1000"`
+	// ExpiresIn is the lifetime in seconds, a week when left out
+	ExpiresIn int `json:"expiresIn" binding:"min=0"`
+}
+
+func (r *RoomHander) CreateInviteLink(ctx *gin.Context) {
+	roomId := ctx.Param("roomId")
+	userId := ctx.GetString("userId")
+
+	var req createInviteLinkRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if req.Role == "" {
+		req.Role = models.RoleMember
+	}
+	if !inviteLinkRoles[req.Role] {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invite links can only grant the member or moderator role"})
+		return
+	}
+
+	ttl := defaultInviteLinkTTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl > maxInviteLinkLifetime {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invite links can be valid for 30 days at most"})
+		return
+	}
+
+	link, err := r.server.CreateInviteLink(roomId, userId, req.Role, req.MaxUses, time.Now().Add(ttl))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message":    "Invite link created successfully",
+		"inviteLink": link,
+		"url":        inviteLinkURL(link.Token),
+	})
+}
+
+func (r *RoomHander) ListInviteLinks(ctx *gin.Context) {
+	links, err := r.server.ListInviteLinks(ctx.Param("roomId"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":     "Fetched invite links successfully",
+		"inviteLinks": links,
+	})
+}
+
+func (r *RoomHander) RevokeInviteLink(ctx *gin.Context) {
+	link, err := r.server.RevokeInviteLink(ctx.Param("roomId"), ctx.Param("linkId"))
+	if err != nil {
+		ctx.JSON(inviteLinkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    "Invite link revoked successfully",
+		"inviteLink": link,
+	})
+}
+
+// GetInviteLink shows which room a link leads to before it is redeemed
+func (r *RoomHander) GetInviteLink(ctx *gin.Context) {
+	link, err := r.server.GetInviteLink(ctx.Param("token"))
+	if err != nil {
+		ctx.JSON(inviteLinkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	room, err := r.server.GetRoom(link.RoomID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Fetched invite link successfully",
+		"room":    room,
+		"role":    link.Role,
+	})
+}
+
+func (r *RoomHander) RedeemInviteLink(ctx *gin.Context) {
+	userId := ctx.GetString("userId")
+
+	link, added, err := r.server.RedeemInviteLink(ctx.Param("token"), userId)
+	if err != nil {
+		ctx.JSON(inviteLinkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	message := "Added to Room"
+	if !added {
+		message = "Already a member of this room"
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"roomId":  link.RoomID,
+	})
+}
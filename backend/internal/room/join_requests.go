@@ -0,0 +1,242 @@
+package room
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"video-chat/internal/mailer"
+	"video-chat/internal/models"
+	"video-chat/internal/utils"
+	"video-chat/internal/websockets"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrJoinRequestNotFound = errors.New("join request not found")
+	ErrJoinRequestReviewed = errors.New("join request has already been reviewed")
+)
+
+type joinRequestInfo struct {
+	models.JoinRequest
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Email     string `json:"email"`
+	Username  string `json:"username"`
+}
+
+// ListJoinRequests pages through a room's join requests with the requester's details
+func (s *RoomService) ListJoinRequests(roomId, status string, limit, offset int) ([]joinRequestInfo, int64, error) {
+	query := s.db.Model(&models.JoinRequest{}).Where("join_requests.room_id = ?", roomId)
+	if status != "" {
+		query = query.Where("join_requests.status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	requests := []joinRequestInfo{}
+	if err := query.
+		Select("join_requests.*, users.first_name, users.last_name, users.email, users.username").
+		Joins("LEFT JOIN users ON users.id = join_requests.user_id").
+		Order("join_requests.created_at ASC").
+		Offset(offset).
+		Limit(limit).
+		Scan(&requests).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return requests, total, nil
+}
+
+// ReviewJoinRequest approves or rejects a pending request. Approval adds the
+// requester as a member and bumps the member count in the same transaction.
+func (s *RoomService) ReviewJoinRequest(roomId, requestId, reviewerId, reason string, approve bool) (*models.JoinRequest, error) {
+	var joinRequest models.JoinRequest
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND room_id = ?", requestId, roomId).
+			First(&joinRequest).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrJoinRequestNotFound
+			}
+			return err
+		}
+
+		if joinRequest.Status != "pending" {
+			return ErrJoinRequestReviewed
+		}
+
+		if approve {
+			if _, err := addRoomMember(tx, roomId, joinRequest.UserID, models.RoleMember); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		joinRequest.Status = "rejected"
+		if approve {
+			joinRequest.Status = "approved"
+		}
+		joinRequest.ReviewedBy = reviewerId
+		joinRequest.ReviewedAt = &now
+		joinRequest.Reason = reason
+		joinRequest.UpdatedAt = now
+
+		return tx.Save(&joinRequest).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &joinRequest, nil
+}
+
+// addRoomMember creates the membership inside tx and updates the room's
+// member count. It reports false for users who are already members.
+func addRoomMember(tx *gorm.DB, roomId, userId, role string) (bool, error) {
+	var existing int64
+	if err := tx.Model(&models.RoomMember{}).
+		Where("room_id = ? AND user_id = ?", roomId, userId).
+		Count(&existing).Error; err != nil {
+		return false, err
+	}
+	if existing > 0 {
+		return false, nil
+	}
+
+	roomMember := &models.RoomMember{
+		ID:        uuid.NewString(),
+		RoomID:    roomId,
+		UserID:    userId,
+		Role:      role,
+		JoinedAt:  time.Now(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := tx.Create(roomMember).Error; err != nil {
+		return false, err
+	}
+
+	if err := tx.Model(&models.Room{}).Where("id = ?", roomId).Update("members_count", gorm.Expr("members_count + ?", 1)).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *RoomHander) ListJoinRequests(ctx *gin.Context) {
+	roomId := ctx.Param("roomId")
+
+	status := ctx.DefaultQuery("status", "pending")
+	if status == "all" {
+		status = ""
+	}
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	requests, total, err := r.server.ListJoinRequests(roomId, status, limit, (page-1)*limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":      "Fetched join requests successfully",
+		"joinRequests": requests,
+		"page":         page,
+		"limit":        limit,
+		"total":        total,
+	})
+}
+
+type reviewJoinRequestRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+func (r *RoomHander) ApproveJoinRequest(ctx *gin.Context) {
+	r.reviewJoinRequest(ctx, true)
+}
+
+func (r *RoomHander) RejectJoinRequest(ctx *gin.Context) {
+	r.reviewJoinRequest(ctx, false)
+}
+
+func (r *RoomHander) reviewJoinRequest(ctx *gin.Context, approve bool) {
+	roomId := ctx.Param("roomId")
+	requestId := ctx.Param("requestId")
+	userId := ctx.GetString("userId")
+
+	// The reason is optional, so is the body
+	var req reviewJoinRequestRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	joinRequest, err := r.server.ReviewJoinRequest(roomId, requestId, userId, req.Reason, approve)
+	if errors.Is(err, ErrJoinRequestNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrJoinRequestReviewed) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	r.hub.SettleLobby(roomId, joinRequest.UserID, approve, joinRequest.Reason)
+	r.notifyJoinRequestDecision(joinRequest)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":     "Join request " + joinRequest.Status,
+		"joinRequest": joinRequest,
+	})
+}
+
+// notifyJoinRequestDecision tells the requester about the outcome, live on
+// any open connection and by email
+func (r *RoomHander) notifyJoinRequestDecision(joinRequest *models.JoinRequest) {
+	payload, _ := json.Marshal(joinRequest)
+	r.hub.SendToUser(joinRequest.UserID, websockets.Message{
+		Type:    websockets.TypeJoinRequestUpdated,
+		RoomID:  joinRequest.RoomID,
+		UserID:  joinRequest.UserID,
+		Content: joinRequest.Status,
+		Payload: payload,
+	})
+
+	room, err := r.server.getRoomDetails(joinRequest.RoomID)
+	if err != nil {
+		return
+	}
+	var user models.User
+	if err := r.server.db.Where("id = ?", joinRequest.UserID).First(&user).Error; err != nil {
+		return
+	}
+
+	link := fmt.Sprintf("%s/room/list", utils.GetEnvOrDefaultValue("UI_HOST", "localhost:3000"))
+	approved := joinRequest.Status == "approved"
+	if err := r.mailer.Send(mailer.JoinRequestDecisionEmail(user.Email, room.Name, approved, joinRequest.Reason, link)); err != nil {
+		fmt.Printf("Failed to send join request decision to %s: %v\n", user.Email, err)
+	}
+}
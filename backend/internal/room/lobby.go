@@ -0,0 +1,123 @@
+package room
+
+import (
+	"errors"
+	"log"
+	"time"
+	"video-chat/internal/models"
+	"video-chat/internal/websockets"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Knock records that the user is waiting in the lobby as a pending join
+// request. Knocking again after a decision reopens the request.
+func (s *RoomService) Knock(roomId, userId, message string) (*models.JoinRequest, error) {
+	if err := s.CheckBan(roomId, userId); err != nil {
+		return nil, err
+	}
+
+	joinRequest, err := s.GetJoinRequest(userId, roomId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.AddJoinRequest(userId, roomId, message)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if joinRequest.Status != "pending" || (message != "" && message != joinRequest.Message) {
+		joinRequest.Status = "pending"
+		joinRequest.Message = message
+		joinRequest.ReviewedBy = ""
+		joinRequest.ReviewedAt = nil
+		joinRequest.Reason = ""
+		joinRequest.UpdatedAt = time.Now()
+		if err := s.db.Save(joinRequest).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return joinRequest, nil
+}
+
+// ReviewLobbyRequest settles the user's pending join request on behalf of
+// an approver in the live meeting
+func (s *RoomService) ReviewLobbyRequest(roomId, userId, reviewerId, reason string, admit bool) error {
+	joinRequest, err := s.GetJoinRequest(userId, roomId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrJoinRequestNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.ReviewJoinRequest(roomId, joinRequest.ID, reviewerId, reason, admit)
+	return err
+}
+
+// ServeLobbyWebsocket lets a user who isn't a member wait in the room's lobby.
+// Approvers connected to the room see a knock and admit or deny it live.
+func (r *RoomHander) ServeLobbyWebsocket(ctx *gin.Context) {
+	roomId := ctx.Param("roomId")
+	userId := ctx.GetString("userId")
+
+	conn, err := websockets.Upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		log.Printf("error upgrading to websocket: %v", err)
+		return
+	}
+
+	room, err := r.server.GetRoom(roomId)
+	if errors.Is(err, ErrRoomNotFound) {
+		websockets.RejectConnection(conn, websockets.CloseRoomNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("error opening lobby of room %s: %v", roomId, err)
+		websockets.RejectConnection(conn, websockets.CloseInternalError, "unable to join lobby")
+		return
+	}
+
+	_, err = r.server.GetRoomMember(userId, roomId)
+	if err == nil {
+		websockets.RejectConnection(conn, websockets.CloseAlreadyMember, "already a member of this room")
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("error opening lobby of room %s: %v", roomId, err)
+		websockets.RejectConnection(conn, websockets.CloseInternalError, "unable to join lobby")
+		return
+	}
+
+	if r.hub.IsRoomLocked(room.ID) {
+		websockets.RejectConnection(conn, websockets.CloseRoomLocked, "meeting is locked")
+		return
+	}
+
+	_, err = r.server.Knock(room.ID, userId, ctx.Query("message"))
+	if errors.Is(err, ErrUserBanned) {
+		websockets.RejectConnection(conn, websockets.CloseForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("error knocking on room %s: %v", roomId, err)
+		websockets.RejectConnection(conn, websockets.CloseInternalError, "unable to join lobby")
+		return
+	}
+
+	// Approvers need to know who is knocking
+	userName, err := r.server.GetUserDisplayName(userId)
+	if err != nil {
+		log.Printf("error loading name of user %s: %v", userId, err)
+		websockets.RejectConnection(conn, websockets.CloseInternalError, "unable to join lobby")
+		return
+	}
+
+	client := websockets.NewClient(r.hub, conn, room.ID, userId, userName)
+	client.InLobby()
+	r.hub.Register(client)
+
+	go client.WritePump()
+	go client.ReadPump()
+}
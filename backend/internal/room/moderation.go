@@ -0,0 +1,33 @@
+package room
+
+import (
+	"video-chat/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// BanFromRoom keeps a user kicked from the live meeting out of the room
+func (s *RoomService) BanFromRoom(roomId, userId, bannedBy, reason string) error {
+	_, err := s.BanUser(roomId, userId, bannedBy, reason, nil)
+	return err
+}
+
+// removeRoomMember deletes the membership inside tx and updates the room's
+// member count. It reports false for users who weren't members.
+func removeRoomMember(tx *gorm.DB, roomId, userId string) (bool, error) {
+	result := tx.Where("room_id = ? AND user_id = ?", roomId, userId).Delete(&models.RoomMember{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	err := tx.Model(&models.Room{}).
+		Where("id = ? AND members_count > 0", roomId).
+		Update("members_count", gorm.Expr("members_count - ?", 1)).Error
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
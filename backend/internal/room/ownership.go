@@ -0,0 +1,214 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"video-chat/internal/models"
+	"video-chat/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrAlreadyOwner      = errors.New("you already own this room")
+	ErrNewOwnerNotMember = errors.New("the new owner must be a member of this room")
+)
+
+// What happens to a room once its last member has left, set with
+// EMPTY_ROOM_POLICY
+const (
+	EmptyRoomArchive = "archive"
+	EmptyRoomDelete  = "delete"
+)
+
+func emptyRoomPolicy() string {
+	if utils.GetEnvOrDefaultValue("EMPTY_ROOM_POLICY", EmptyRoomArchive) == EmptyRoomDelete {
+		return EmptyRoomDelete
+	}
+	return EmptyRoomArchive
+}
+
+// lockRoom loads the room inside tx and holds it until tx ends, so
+// membership changes to it happen one at a time
+func lockRoom(tx *gorm.DB, roomId string) (*models.Room, error) {
+	var room models.Room
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND archived_at IS NULL", roomId).
+		First(&room).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// setOwner makes the member the room's owner. The room's created_by always
+// points at the current owner.
+func setOwner(tx *gorm.DB, roomMember *models.RoomMember) error {
+	roomMember.Role = models.RoleOwner
+	roomMember.UpdatedAt = time.Now()
+	if err := tx.Save(roomMember).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&models.Room{}).Where("id = ?", roomMember.RoomID).Update("created_by", roomMember.UserID).Error
+}
+
+// TransferOwnership hands the room to another member. The previous owner
+// stays on as an admin.
+func (s *RoomService) TransferOwnership(roomId, ownerId, newOwnerId string) (*models.RoomMember, error) {
+	if ownerId == newOwnerId {
+		return nil, ErrAlreadyOwner
+	}
+
+	var newOwner models.RoomMember
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockRoom(tx, roomId); err != nil {
+			return err
+		}
+
+		if err := tx.Where("room_id = ? AND user_id = ?", roomId, newOwnerId).First(&newOwner).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNewOwnerNotMember
+			}
+			return err
+		}
+
+		if err := tx.Model(&models.RoomMember{}).
+			Where("room_id = ? AND user_id = ?", roomId, ownerId).
+			Updates(map[string]any{"role": models.RoleAdmin, "updated_at": time.Now()}).Error; err != nil {
+			return err
+		}
+
+		return setOwner(tx, &newOwner)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &newOwner, nil
+}
+
+// leaveResult tells the leaving member what became of the room
+type leaveResult struct {
+	// NewOwner is set when the owner left and ownership passed on
+	NewOwner *models.RoomMember `json:"newOwner,omitempty"`
+	// Emptied is the EMPTY_ROOM_POLICY applied when the last member left
+	Emptied string `json:"emptied,omitempty"`
+}
+
+// LeaveRoom removes the user's membership. When the owner leaves, the
+// longest-serving admin takes over, or the longest-serving member if there
+// is no admin. When nobody is left the room is archived or deleted.
+func (s *RoomService) LeaveRoom(roomId, userId string) (*leaveResult, error) {
+	result := &leaveResult{}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockRoom(tx, roomId); err != nil {
+			return err
+		}
+
+		var roomMember models.RoomMember
+		if err := tx.Where("room_id = ? AND user_id = ?", roomId, userId).First(&roomMember).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotRoomMember
+			}
+			return err
+		}
+
+		if _, err := removeRoomMember(tx, roomId, userId); err != nil {
+			return err
+		}
+
+		var successor models.RoomMember
+		err := tx.Where("room_id = ?", roomId).
+			Order(fmt.Sprintf("CASE WHEN role = '%s' THEN 0 ELSE 1 END", models.RoleAdmin)).
+			Order("joined_at ASC").
+			First(&successor).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			result.Emptied = emptyRoomPolicy()
+			if result.Emptied == EmptyRoomDelete {
+				return deleteRoom(tx, roomId)
+			}
+			return archiveRoom(tx, roomId)
+		}
+		if err != nil {
+			return err
+		}
+
+		if roomMember.Role != models.RoleOwner {
+			return nil
+		}
+
+		result.NewOwner = &successor
+		return setOwner(tx, &successor)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// archiveRoom hides an empty room while keeping its messages and stats.
+// Nothing that could bring someone back into it is left open.
+func archiveRoom(tx *gorm.DB, roomId string) error {
+	now := time.Now()
+
+	if err := tx.Model(&models.RoomInviteLink{}).
+		Where("room_id = ? AND revoked_at IS NULL", roomId).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("room_id = ?", roomId).Delete(&models.InvitedMember{}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("room_id = ? AND status = ?", roomId, "pending").Delete(&models.JoinRequest{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&models.Room{}).Where("id = ?", roomId).Update("archived_at", now).Error
+}
+
+type transferOwnershipRequest struct {
+	UserID string `json:"userId" binding:"required"`
+}
+
+func (r *RoomHander) TransferOwnership(ctx *gin.Context) {
+	roomId := ctx.Param("roomId")
+	userId := ctx.GetString("userId")
+
+	var req transferOwnershipRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	newOwner, err := r.server.TransferOwnership(roomId, userId, req.UserID)
+	if errors.Is(err, ErrAlreadyOwner) || errors.Is(err, ErrNewOwnerNotMember) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrRoomNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to transfer ownership of room %s: %v\n", roomId, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer ownership"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":  "Ownership transferred successfully",
+		"newOwner": newOwner,
+	})
+}
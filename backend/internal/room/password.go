@@ -0,0 +1,199 @@
+package room
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"video-chat/internal/models"
+	"video-chat/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRoomNotPasswordProtected = errors.New("room is not password protected")
+	ErrWrongRoomPassword        = errors.New("wrong room password")
+)
+
+const (
+	// maxPasswordAttempts wrong passwords lock a user out of a room for the
+	// rest of passwordAttemptWindow
+	maxPasswordAttempts   = 5
+	passwordAttemptWindow = 15 * time.Minute
+
+	// roomPassTTL is how long a correct password lets a member connect to
+	// the room's live session
+	roomPassTTL = 12 * time.Hour
+)
+
+func hashRoomPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkRoomPassword compares a password with the stored one. Rooms created
+// before passwords were hashed still hold the plaintext, which is reported
+// so the caller can replace it.
+func checkRoomPassword(stored, password string) (ok bool, legacy bool) {
+	if !strings.HasPrefix(stored, "$2") {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+}
+
+// JoinWithPassword checks the room password and makes the caller a member
+// if they aren't one yet
+func (s *RoomService) JoinWithPassword(roomId, userId, password string) (*models.Room, *models.RoomMember, error) {
+	room, err := s.GetRoom(roomId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !room.RequirePassword || room.Password == "" {
+		return nil, nil, ErrRoomNotPasswordProtected
+	}
+
+	if err := s.CheckBan(roomId, userId); err != nil {
+		return nil, nil, err
+	}
+
+	ok, legacy := checkRoomPassword(room.Password, password)
+	if !ok {
+		return nil, nil, ErrWrongRoomPassword
+	}
+	if legacy {
+		if hash, err := hashRoomPassword(password); err == nil {
+			if err := s.db.Model(room).Update("password", hash).Error; err != nil {
+				fmt.Printf("Failed to hash password of room %s: %v\n", roomId, err)
+			} else {
+				room.Password = hash
+			}
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		_, err := addRoomMember(tx, roomId, userId, models.RoleMember)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roomMember, err := s.GetRoomMember(userId, roomId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return room, roomMember, nil
+}
+
+func passwordAttemptsKey(roomId, userId string) string {
+	return "room-password-attempts-" + roomId + "-" + userId
+}
+
+func roomPassKey(roomId, userId string) string {
+	return "room-pass-" + roomId + "-" + userId
+}
+
+// passwordFingerprint identifies the current room password without storing
+// its hash again, so changing the password invalidates every pass
+func passwordFingerprint(room *models.Room) string {
+	sum := sha256.Sum256([]byte(room.Password))
+	return hex.EncodeToString(sum[:8])
+}
+
+// hasRoomPass reports whether the user entered the current password of a
+// protected room recently. Members allowed to change the settings know the
+// password anyway and are never asked for it.
+func (r *RoomHander) hasRoomPass(room *models.Room, roomMember *models.RoomMember) bool {
+	if !room.RequirePassword || HasPermission(roomMember.Role, PermChangeSettings) {
+		return true
+	}
+
+	pass, err := r.redisClient.Get(r.ctx, roomPassKey(room.ID, roomMember.UserID)).Result()
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(pass), []byte(passwordFingerprint(room))) == 1
+}
+
+type joinWithPasswordRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+func (r *RoomHander) JoinWithPassword(ctx *gin.Context) {
+	roomId := ctx.Param("roomId")
+	userId := ctx.GetString("userId")
+
+	var req joinWithPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Password is required"})
+		return
+	}
+
+	// The attempt is counted before the password is checked, so a burst of
+	// concurrent guesses can't get past the limit
+	attemptsKey := passwordAttemptsKey(roomId, userId)
+	attempts, err := utils.CountAttempt(r.ctx, r.redisClient, attemptsKey, passwordAttemptWindow)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check password attempts"})
+		return
+	}
+	if attempts > maxPasswordAttempts {
+		retryAfter, _ := r.redisClient.TTL(r.ctx, attemptsKey).Result()
+		ctx.JSON(http.StatusTooManyRequests, gin.H{
+			"error":      "Too many wrong passwords. Try again later.",
+			"retryAfter": int(retryAfter.Seconds()),
+		})
+		return
+	}
+
+	room, roomMember, err := r.server.JoinWithPassword(roomId, userId, req.Password)
+	if errors.Is(err, ErrWrongRoomPassword) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error":             err.Error(),
+			"attemptsRemaining": max(maxPasswordAttempts-int(attempts), 0),
+		})
+		return
+	}
+	if err != nil {
+		// Only wrong passwords count against the user
+		r.redisClient.Decr(r.ctx, attemptsKey)
+	}
+	if errors.Is(err, ErrRoomNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrUserBanned) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrRoomNotPasswordProtected) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to join room %s with password: %v\n", roomId, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join room"})
+		return
+	}
+
+	r.redisClient.Del(r.ctx, attemptsKey)
+	if err := r.redisClient.Set(r.ctx, roomPassKey(roomId, userId), passwordFingerprint(room), roomPassTTL).Err(); err != nil {
+		fmt.Printf("Failed to store room pass: %v\n", err)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    "Joined room successfully",
+		"roomMember": roomMember,
+	})
+}
@@ -0,0 +1,189 @@
+package room
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"video-chat/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Permission is an operation on a room that not every member may perform
+type Permission string
+
+const (
+	PermDeleteRoom     Permission = "delete_room"
+	PermInvite         Permission = "invite"
+	PermCancelInvite   Permission = "cancel_invite"
+	PermApproveJoins   Permission = "approve_joins"
+	PermModerateChat   Permission = "moderate_chat"
+	PermChangeSettings Permission = "change_settings"
+	PermManageBans     Permission = "manage_bans"
+	PermManageRoles    Permission = "manage_roles"
+
+	PermTransferOwnership Permission = "transfer_ownership"
+	PermViewAttendance    Permission = "view_attendance"
+)
+
+// ErrCodePermissionDenied is the error code of every 403 caused by a
+// missing room permission
+const ErrCodePermissionDenied = "permission_denied"
+
+// rolePermissions is the permission matrix. Members and guests only get
+// what every participant can do, so they have no entry.
+var rolePermissions = map[string]map[Permission]bool{
+	models.RoleOwner: {
+		PermDeleteRoom:        true,
+		PermTransferOwnership: true,
+		PermInvite:            true,
+		PermCancelInvite:      true,
+		PermApproveJoins:      true,
+		PermModerateChat:      true,
+		PermChangeSettings:    true,
+		PermManageBans:        true,
+		PermManageRoles:       true,
+		PermViewAttendance:    true,
+	},
+	models.RoleAdmin: {
+		PermInvite:         true,
+		PermCancelInvite:   true,
+		PermApproveJoins:   true,
+		PermModerateChat:   true,
+		PermChangeSettings: true,
+		PermManageBans:     true,
+		PermManageRoles:    true,
+		PermViewAttendance: true,
+	},
+	models.RoleModerator: {
+		PermModerateChat: true,
+	},
+}
+
+// roleRanks orders the roles by privilege. Members and guests share the
+// lowest rank, so they have no entry.
+var roleRanks = map[string]int{
+	models.RoleOwner:     3,
+	models.RoleAdmin:     2,
+	models.RoleModerator: 1,
+}
+
+// Outranks reports whether role is above other, the only way one member
+// gets to act on another's membership
+func Outranks(role, other string) bool {
+	return roleRanks[role] > roleRanks[other]
+}
+
+// HasPermission reports whether the role grants perm
+func HasPermission(role string, perm Permission) bool {
+	return rolePermissions[role][perm]
+}
+
+// RequirePermission only lets members of the :roomId room through when
+// their role grants perm. The member is stored as "roomMember" for the
+// handler.
+func (r *RoomHander) RequirePermission(perm Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		roomMember, err := r.server.GetRoomMember(ctx.GetString("userId"), ctx.Param("roomId"))
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err != nil || !HasPermission(roomMember.Role, perm) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":      "You don't have permission to do this",
+				"code":       ErrCodePermissionDenied,
+				"permission": perm,
+			})
+			return
+		}
+
+		ctx.Set("roomMember", roomMember)
+		ctx.Next()
+	}
+}
+
+// RequireMember only lets members of the :roomId room through, whatever
+// their role. The member is stored as "roomMember" for the handler.
+func (r *RoomHander) RequireMember() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		roomMember, err := r.server.GetRoomMember(ctx.GetString("userId"), ctx.Param("roomId"))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": ErrNotRoomMember.Error()})
+			return
+		}
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx.Set("roomMember", roomMember)
+		ctx.Next()
+	}
+}
+
+// HasRoomPermission checks the user's current role in the room. Users who
+// aren't members have no permissions.
+func (s *RoomService) HasRoomPermission(roomId, userId, perm string) (bool, error) {
+	roomMember, err := s.GetRoomMember(userId, roomId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return HasPermission(roomMember.Role, Permission(perm)), nil
+}
+
+// OutranksMember reports whether the actor's current role is above the
+// target's. Users who aren't members, guests among them, have the lowest
+// rank.
+func (s *RoomService) OutranksMember(roomId, actorId, targetId string) (bool, error) {
+	roles := make([]string, 2)
+	for i, userId := range []string{actorId, targetId} {
+		roomMember, err := s.GetRoomMember(userId, roomId)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, err
+		}
+		if err == nil {
+			roles[i] = roomMember.Role
+		}
+	}
+
+	return Outranks(roles[0], roles[1]), nil
+}
+
+// RolesOutranked lists the roles below the actor's current role, guests
+// included
+func (s *RoomService) RolesOutranked(roomId, actorId string) ([]string, error) {
+	roomMember, err := s.GetRoomMember(actorId, roomId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []string{}
+	for _, role := range []string{models.RoleOwner, models.RoleAdmin, models.RoleModerator, models.RoleMember, models.RoleGuest} {
+		if Outranks(roomMember.Role, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+// RolesWithPermission lists the roles that grant perm
+func (s *RoomService) RolesWithPermission(perm string) []string {
+	roles := []string{}
+	for role, permissions := range rolePermissions {
+		if permissions[Permission(perm)] {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
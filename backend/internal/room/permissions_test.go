@@ -0,0 +1,44 @@
+package room
+
+import (
+	"testing"
+	"video-chat/internal/models"
+)
+
+func TestOutranks(t *testing.T) {
+	tests := []struct {
+		role, other string
+		want        bool
+	}{
+		{models.RoleOwner, models.RoleAdmin, true},
+		{models.RoleOwner, models.RoleOwner, false},
+		{models.RoleAdmin, models.RoleModerator, true},
+		{models.RoleAdmin, models.RoleAdmin, false},
+		{models.RoleAdmin, models.RoleOwner, false},
+		{models.RoleModerator, models.RoleMember, true},
+		{models.RoleModerator, models.RoleGuest, true},
+		{models.RoleModerator, models.RoleAdmin, false},
+		{models.RoleMember, models.RoleGuest, false},
+		{models.RoleGuest, models.RoleMember, false},
+	}
+
+	for _, tt := range tests {
+		if got := Outranks(tt.role, tt.other); got != tt.want {
+			t.Errorf("Outranks(%s, %s) = %v, want %v", tt.role, tt.other, got, tt.want)
+		}
+	}
+}
+
+func TestManageRolesPermission(t *testing.T) {
+	for role, want := range map[string]bool{
+		models.RoleOwner:     true,
+		models.RoleAdmin:     true,
+		models.RoleModerator: false,
+		models.RoleMember:    false,
+		models.RoleGuest:     false,
+	} {
+		if got := HasPermission(role, PermManageRoles); got != want {
+			t.Errorf("HasPermission(%s, manage_roles) = %v, want %v", role, got, want)
+		}
+	}
+}
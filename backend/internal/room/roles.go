@@ -0,0 +1,115 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"video-chat/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidRole  = errors.New("role must be admin, moderator or member")
+	ErrRoleOutranks = errors.New("you can only change the role of members below you")
+	ErrRoleTooHigh  = errors.New("you can only grant roles below your own")
+
+	ErrTargetNotMember = errors.New("the user is not a member of this room")
+)
+
+// assignableRoles are the roles ChangeMemberRole can grant. Ownership only
+// changes hands through TransferOwnership.
+var assignableRoles = map[string]bool{
+	models.RoleAdmin:     true,
+	models.RoleModerator: true,
+	models.RoleMember:    true,
+}
+
+// ChangeMemberRole gives a member a new role. The caller must outrank both
+// the member's current role and the one granted.
+func (s *RoomService) ChangeMemberRole(roomId, actorId, userId, role string) (*models.RoomMember, error) {
+	if !assignableRoles[role] {
+		return nil, ErrInvalidRole
+	}
+
+	var roomMember models.RoomMember
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockRoom(tx, roomId); err != nil {
+			return err
+		}
+
+		var actor models.RoomMember
+		if err := tx.Where("room_id = ? AND user_id = ?", roomId, actorId).First(&actor).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotRoomMember
+			}
+			return err
+		}
+
+		if err := tx.Where("room_id = ? AND user_id = ?", roomId, userId).First(&roomMember).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTargetNotMember
+			}
+			return err
+		}
+
+		if !Outranks(actor.Role, roomMember.Role) {
+			return ErrRoleOutranks
+		}
+		if !Outranks(actor.Role, role) {
+			return ErrRoleTooHigh
+		}
+
+		roomMember.Role = role
+		roomMember.UpdatedAt = time.Now()
+		return tx.Save(&roomMember).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &roomMember, nil
+}
+
+type changeMemberRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+func (r *RoomHander) ChangeMemberRole(ctx *gin.Context) {
+	var req changeMemberRoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	roomId := ctx.Param("roomId")
+	roomMember, err := r.server.ChangeMemberRole(roomId, ctx.GetString("userId"), ctx.Param("userId"), req.Role)
+	if errors.Is(err, ErrInvalidRole) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrRoleOutranks) || errors.Is(err, ErrRoleTooHigh) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrTargetNotMember) || errors.Is(err, ErrRoomNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrNotRoomMember) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to change member role in room %s: %v\n", roomId, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change member role"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Changed member role successfully",
+		"member":  roomMember,
+	})
+}
@@ -1,6 +1,8 @@
 package room
 
 import (
+	"errors"
+	"strings"
 	"time"
 	"video-chat/internal/models"
 
@@ -9,6 +11,24 @@ import (
 	"gorm.io/gorm/clause"
 )
 
+var (
+	ErrRoomNotFound         = errors.New("room not found")
+	ErrNotRoomMember        = errors.New("you are not a member of this room")
+	ErrRoomPasswordRequired = errors.New("room password required")
+
+	ErrEmptyMessage      = errors.New("message is empty")
+	ErrMessageTooLong    = errors.New("Message too long")
+	ErrChatDisabled      = errors.New("chat is disabled in this room")
+	ErrMessageNotFound   = errors.New("Message Not found")
+	ErrNotMessageOwner   = errors.New("You can only change your own messages")
+	ErrEditWindowExpired = errors.New("Edit window expired")
+)
+
+const (
+	maxMessageLength  = 2500
+	messageEditWindow = time.Hour
+)
+
 type RoomService struct {
 	db *gorm.DB
 }
@@ -18,6 +38,14 @@ func NewRoomService(db *gorm.DB) *RoomService {
 }
 
 func (s *RoomService) CreateRoom(req CreateRoomRequest, userId string) (*models.Room, error) {
+	password := ""
+	if req.IsPasswordProtected {
+		hash, err := hashRoomPassword(req.Password)
+		if err != nil {
+			return nil, err
+		}
+		password = hash
+	}
 
 	tx := s.db.Begin()
 	if tx.Error != nil {
@@ -33,7 +61,7 @@ func (s *RoomService) CreateRoom(req CreateRoomRequest, userId string) (*models.
 		IsPrivate:       req.IsPrivate,
 		MaxUsers:        req.MaxParticipants,
 		RequirePassword: req.IsPasswordProtected,
-		Password:        req.Password,
+		Password:        password,
 		MembersCount:    1,
 	}
 
@@ -46,7 +74,7 @@ func (s *RoomService) CreateRoom(req CreateRoomRequest, userId string) (*models.
 		ID:        uuid.NewString(),
 		RoomID:    room.ID,
 		UserID:    userId,
-		Role:      "admin",
+		Role:      models.RoleOwner,
 		JoinedAt:  time.Now(),
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -74,66 +102,101 @@ func (s *RoomService) GetRoomMember(userId, roomId string) (*models.RoomMember,
 	return roomMember, nil
 }
 
-func (s *RoomService) DeleteRoomMember(roomMember *models.RoomMember) error {
-	tx := s.db.Begin()
-	if tx.Error != nil {
-		return tx.Error
+// GetUserDisplayName returns the name the user is shown by in live meetings
+func (s *RoomService) GetUserDisplayName(userId string) (string, error) {
+	var user models.User
+	if err := s.db.Where("id = ?", userId).First(&user).Error; err != nil {
+		return "", err
 	}
 
-	if err := tx.Delete(roomMember).Error; err != nil {
-		tx.Rollback()
-		return err
+	if name := strings.TrimSpace(user.FirstName + " " + user.LastName); name != "" {
+		return name, nil
 	}
+	return user.Username, nil
+}
 
-	if err := s.updateRoomMemberCount(roomMember.RoomID, false); err != nil {
-		tx.Rollback()
-		return err
+// AuthorizeRoomConnection applies the same membership checks as the message
+// endpoints before a user is allowed into a room's live session
+func (s *RoomService) AuthorizeRoomConnection(userId, roomId string) (*models.Room, *models.RoomMember, error) {
+	room, err := s.getRoomDetails(roomId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return tx.Commit().Error
+	if err := s.CheckBan(roomId, userId); err != nil {
+		return nil, nil, err
+	}
+
+	roomMember, err := s.GetRoomMember(userId, roomId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if room.RequirePassword {
+			return nil, nil, ErrRoomPasswordRequired
+		}
+		return nil, nil, ErrNotRoomMember
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return room, roomMember, nil
 }
 
 func (s *RoomService) DeleteRoom(roomId string) error {
-	tx := s.db.Begin()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return deleteRoom(tx, roomId)
+	})
+}
 
+// deleteRoom removes the room and everything that belongs to it inside tx
+func deleteRoom(tx *gorm.DB, roomId string) error {
 	// Delete Room Members
 	if err := tx.Where("room_id = ?", roomId).Delete(&models.RoomMember{}).Error; err != nil {
-		tx.Rollback()
 		return err
 	}
 
 	// Delete invited members by roomId
 	if err := tx.Where("room_id = ?", roomId).Delete(&models.InvitedMember{}).Error; err != nil {
-		tx.Rollback()
 		return err
 	}
 
 	// Delete Join request by roomId
 	if err := tx.Where("room_id = ?", roomId).Delete(&models.JoinRequest{}).Error; err != nil {
-		tx.Rollback()
+		return err
+	}
+
+	// Delete invite links by roomId
+	if err := tx.Where("room_id = ?", roomId).Delete(&models.RoomInviteLink{}).Error; err != nil {
+		return err
+	}
+
+	// Delete bans by roomId
+	if err := tx.Where("room_id = ?", roomId).Delete(&models.RoomBan{}).Error; err != nil {
+		return err
+	}
+
+	// Delete meeting sessions by roomId
+	if err := tx.Where("room_id = ?", roomId).Delete(&models.SessionParticipant{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("room_id = ?", roomId).Delete(&models.MeetingSession{}).Error; err != nil {
 		return err
 	}
 
 	// Delete Room Stats by roomId
 	if err := tx.Where("room_id = ?", roomId).Delete(&models.RoomStats{}).Error; err != nil {
-		tx.Rollback()
 		return err
 	}
 
 	// Delete Message by roomId
 	if err := tx.Where("room_id = ?", roomId).Delete(&models.Message{}).Error; err != nil {
-		tx.Rollback()
 		return err
 	}
 
 	// Delete Room by roomId
 	if err := tx.Where("id = ?", roomId).Delete(&models.Room{}).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	if err := tx.Commit().Error; err != nil {
-		tx.Rollback()
 		return err
 	}
 
@@ -155,7 +218,7 @@ func (s *RoomService) GetJoinedRooms(userId string) ([]models.Room, error) {
 
 	if err := s.db.
 		Model(&models.Room{}).
-		Where("id IN (?) OR created_by = ?", subQuery, userId).
+		Where("id IN (?)", subQuery).
 		Find(&rooms).Error; err != nil {
 		return nil, err
 	}
@@ -241,7 +304,7 @@ func (s *RoomService) GetPublicRooms(userId string, limit, offset int) ([]models
 	if err := s.db.
 		Model(&models.Room{}).
 		Where(`
-			is_private = FALSE AND 
+			is_private = FALSE AND archived_at IS NULL AND
 			NOT EXISTS (SELECT 1 FROM room_members WHERE room_members.room_id = rooms.id AND user_id = ?) AND
 			NOT EXISTS (SELECT 1 FROM join_requests WHERE join_requests.room_id = rooms.id AND user_id = ?) AND
 			NOT EXISTS (
@@ -302,6 +365,7 @@ func (s *RoomService) AcceptRoomInvite(userId, roomId string, invitee *models.In
 		ID:        uuid.NewString(),
 		RoomID:    roomId,
 		UserID:    userId,
+		Role:      models.RoleMember,
 		JoinedAt:  time.Now(),
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -376,10 +440,19 @@ func (s *RoomService) CancelJoinReqest(joinRequest *models.JoinRequest) error {
 	return nil
 }
 
+// GetRoom returns a room, with ErrRoomNotFound for unknown IDs
+func (s *RoomService) GetRoom(roomId string) (*models.Room, error) {
+	room, err := s.getRoomDetails(roomId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRoomNotFound
+	}
+	return room, err
+}
+
 func (s *RoomService) getRoomDetails(roomId string) (*models.Room, error) {
 	var room *models.Room
 
-	if err := s.db.Where("id = ?", roomId).First(&room).Error; err != nil {
+	if err := s.db.Where("id = ? AND archived_at IS NULL", roomId).First(&room).Error; err != nil {
 		return nil, err
 	}
 
@@ -409,6 +482,166 @@ func (s *RoomService) updateRoomMemberCount(roomId string, increment bool) error
 	})
 }
 
+// CreateMessage validates a chat message from a room member and stores it
+func (s *RoomService) CreateMessage(roomId, userId, content string) (*models.Message, error) {
+	if err := validateMessageContent(content); err != nil {
+		return nil, err
+	}
+
+	room, err := s.GetRoom(roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !room.AllowChat {
+		return nil, ErrChatDisabled
+	}
+
+	roomMember, err := s.GetRoomMember(userId, roomId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotRoomMember
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storeMessage(room.ID, roomMember.UserID, content)
+}
+
+// CreateGuestMessage stores a chat message from a guest. Guests have no
+// membership, their token was checked when they connected.
+func (s *RoomService) CreateGuestMessage(roomId, guestId, content string) (*models.Message, error) {
+	if err := validateMessageContent(content); err != nil {
+		return nil, err
+	}
+
+	room, err := s.GetRoom(roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !room.AllowChat {
+		return nil, ErrChatDisabled
+	}
+
+	return s.storeMessage(room.ID, guestId, content)
+}
+
+func (s *RoomService) storeMessage(roomId, userId, content string) (*models.Message, error) {
+	message := &models.Message{
+		ID:        uuid.NewString(),
+		RoomID:    roomId,
+		UserID:    userId,
+		Content:   content,
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.addMessage(message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// UpdateMessage changes the content of the caller's own message within the
+// edit window
+func (s *RoomService) UpdateMessage(roomId, messageId, userId, content string) (*models.Message, error) {
+	if err := validateMessageContent(content); err != nil {
+		return nil, err
+	}
+
+	room, err := s.GetRoom(roomId)
+	if err != nil {
+		return nil, err
+	}
+	if !room.AllowChat {
+		return nil, ErrChatDisabled
+	}
+
+	message, err := s.getOwnMessage(roomId, messageId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	message.Content = content
+	message.UpdatedAt = time.Now()
+	if err := s.editMessage(message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// RemoveMessage deletes the caller's own message within the edit window.
+// Members whose role grants moderate_chat can delete any message in the room
+// at any time.
+func (s *RoomService) RemoveMessage(roomId, messageId, userId string) (*models.Message, error) {
+	moderator, err := s.HasRoomPermission(roomId, userId, string(PermModerateChat))
+	if err != nil {
+		return nil, err
+	}
+
+	var message *models.Message
+	if moderator {
+		message, err = s.getRoomMessage(roomId, messageId)
+	} else {
+		message, err = s.getOwnMessage(roomId, messageId, userId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.deleteMessage(message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (s *RoomService) getRoomMessage(roomId, messageId string) (*models.Message, error) {
+	message, err := s.getMessageById(messageId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && message.RoomID != roomId) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (s *RoomService) getOwnMessage(roomId, messageId, userId string) (*models.Message, error) {
+	message, err := s.getMessageById(messageId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+
+	if message.UserID != userId || message.RoomID != roomId {
+		return nil, ErrNotMessageOwner
+	}
+
+	if time.Since(message.CreatedAt) > messageEditWindow {
+		return nil, ErrEditWindowExpired
+	}
+
+	return message, nil
+}
+
+func validateMessageContent(content string) error {
+	if strings.TrimSpace(content) == "" {
+		return ErrEmptyMessage
+	}
+
+	if len(content) > maxMessageLength {
+		return ErrMessageTooLong
+	}
+
+	return nil
+}
+
 func (s *RoomService) addMessage(message *models.Message) error {
 	if err := s.db.Create(message).Error; err != nil {
 		return err
@@ -0,0 +1,305 @@
+package room
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"video-chat/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrMeetingSessionNotFound = errors.New("meeting session not found")
+
+// StartMeetingSession records that the first participant joined the room
+func (s *RoomService) StartMeetingSession(roomId, sessionId string, startedAt time.Time) error {
+	session := &models.MeetingSession{
+		ID:        sessionId,
+		RoomID:    roomId,
+		StartedAt: startedAt,
+	}
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(session).Error
+}
+
+// JoinMeetingSession opens a participant's stretch in the meeting
+func (s *RoomService) JoinMeetingSession(roomId, sessionId, userId, displayName string, isGuest bool, joinedAt time.Time) error {
+	participant := &models.SessionParticipant{
+		ID:          uuid.NewString(),
+		SessionID:   sessionId,
+		RoomID:      roomId,
+		UserID:      userId,
+		DisplayName: displayName,
+		IsGuest:     isGuest,
+		JoinedAt:    joinedAt,
+	}
+	return s.db.Create(participant).Error
+}
+
+// RejoinMeetingSession counts a reconnect in the participant's open stretch
+func (s *RoomService) RejoinMeetingSession(roomId, sessionId, userId string) error {
+	return s.db.Model(&models.SessionParticipant{}).
+		Where("session_id = ? AND user_id = ? AND left_at IS NULL", sessionId, userId).
+		Update("reconnects", gorm.Expr("reconnects + ?", 1)).Error
+}
+
+// LeaveMeetingSession closes the participant's open stretch in the meeting
+func (s *RoomService) LeaveMeetingSession(roomId, sessionId, userId string, leftAt time.Time) error {
+	return s.db.Model(&models.SessionParticipant{}).
+		Where("session_id = ? AND user_id = ? AND left_at IS NULL", sessionId, userId).
+		Update("left_at", leftAt).Error
+}
+
+// OpenMeetingSessions lists the meetings started before the given time that
+// haven't ended
+func (s *RoomService) OpenMeetingSessions(startedBefore time.Time) ([]models.MeetingSession, error) {
+	sessions := []models.MeetingSession{}
+	err := s.db.Where("ended_at IS NULL AND started_at < ?", startedBefore).Find(&sessions).Error
+	return sessions, err
+}
+
+// OpenSessionParticipants lists the users with a stretch in the meeting that
+// started before the given time and is still open
+func (s *RoomService) OpenSessionParticipants(sessionId string, joinedBefore time.Time) ([]string, error) {
+	users := []string{}
+	err := s.db.Model(&models.SessionParticipant{}).
+		Distinct().
+		Where("session_id = ? AND left_at IS NULL AND joined_at < ?", sessionId, joinedBefore).
+		Pluck("user_id", &users).Error
+	return users, err
+}
+
+// EndMeetingSession closes the meeting and whatever participants were still
+// open in it, then rolls the room's stats up again. The meeting is over when
+// its last participant left, which can be earlier than endedAt.
+func (s *RoomService) EndMeetingSession(roomId, sessionId string, endedAt time.Time) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var session models.MeetingSession
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND room_id = ?", sessionId, roomId).
+			First(&session).Error; err != nil {
+			return err
+		}
+		if session.EndedAt != nil {
+			return nil
+		}
+
+		if err := tx.Model(&models.SessionParticipant{}).
+			Where("session_id = ? AND left_at IS NULL", sessionId).
+			Update("left_at", endedAt).Error; err != nil {
+			return err
+		}
+
+		// Participants only report the bytes they received, so summing them
+		// up counts the meeting's traffic once
+		var summary struct {
+			LastLeft     *time.Time
+			Participants int
+			Bytes        int64
+		}
+		if err := tx.Model(&models.SessionParticipant{}).
+			Select("MAX(left_at) AS last_left, COUNT(DISTINCT user_id) AS participants, COALESCE(SUM(bytes_transferred), 0) AS bytes").
+			Where("session_id = ?", sessionId).
+			Scan(&summary).Error; err != nil {
+			return err
+		}
+
+		if summary.LastLeft != nil && summary.LastLeft.Before(endedAt) {
+			endedAt = *summary.LastLeft
+		}
+		if endedAt.Before(session.StartedAt) {
+			endedAt = session.StartedAt
+		}
+
+		if err := tx.Model(&session).Updates(map[string]any{
+			"ended_at":           endedAt,
+			"duration":           int(endedAt.Sub(session.StartedAt).Seconds()),
+			"participants_count": summary.Participants,
+			"bandwidth_usage":    summary.Bytes,
+		}).Error; err != nil {
+			return err
+		}
+
+		return rollUpRoomStats(tx, roomId)
+	})
+}
+
+// rollUpRoomStats recomputes the room's stats from its finished sessions
+func rollUpRoomStats(tx *gorm.DB, roomId string) error {
+	var meetings struct {
+		Total           int
+		AverageDuration float64
+		BandwidthUsage  int64
+	}
+	if err := tx.Model(&models.MeetingSession{}).
+		Select("COUNT(*) AS total, COALESCE(AVG(duration), 0) AS average_duration, COALESCE(SUM(bandwidth_usage), 0) AS bandwidth_usage").
+		Where("room_id = ? AND ended_at IS NOT NULL", roomId).
+		Scan(&meetings).Error; err != nil {
+		return err
+	}
+
+	var participants int64
+	if err := tx.Model(&models.SessionParticipant{}).
+		Distinct("user_id").
+		Where("room_id = ?", roomId).
+		Count(&participants).Error; err != nil {
+		return err
+	}
+
+	var stats models.RoomStats
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("room_id = ?", roomId).First(&stats).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		stats = models.RoomStats{ID: uuid.NewString(), RoomID: roomId}
+	} else if err != nil {
+		return err
+	}
+
+	stats.TotalMeetings = meetings.Total
+	stats.AverageDuration = int(meetings.AverageDuration)
+	stats.TotalParticipants = int(participants)
+	stats.BandwidthUsage = meetings.BandwidthUsage
+	stats.UpdatedAt = time.Now()
+	return tx.Save(&stats).Error
+}
+
+// GetRoomStats returns the room's rolled up stats, all zero before its
+// first meeting has ended
+func (s *RoomService) GetRoomStats(roomId string) (*models.RoomStats, error) {
+	var stats models.RoomStats
+	err := s.db.Where("room_id = ?", roomId).First(&stats).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.RoomStats{RoomID: roomId}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// GetLiveMeetingSession returns the meeting in progress, or nil
+func (s *RoomService) GetLiveMeetingSession(roomId string) (*models.MeetingSession, error) {
+	var session models.MeetingSession
+	err := s.db.Where("room_id = ? AND ended_at IS NULL", roomId).Order("started_at DESC").First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *RoomService) ListMeetingSessions(roomId string, limit, offset int) ([]models.MeetingSession, int64, error) {
+	query := s.db.Model(&models.MeetingSession{}).Where("room_id = ?", roomId)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	sessions := []models.MeetingSession{}
+	if err := query.Order("started_at DESC").Offset(offset).Limit(limit).Find(&sessions).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return sessions, total, nil
+}
+
+type sessionParticipantInfo struct {
+	models.SessionParticipant
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Username  string `json:"username"`
+}
+
+// GetMeetingSession returns a meeting with every participant's stretches in
+// the order they joined
+func (s *RoomService) GetMeetingSession(roomId, sessionId string) (*models.MeetingSession, []sessionParticipantInfo, error) {
+	var session models.MeetingSession
+	if err := s.db.Where("id = ? AND room_id = ?", sessionId, roomId).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrMeetingSessionNotFound
+		}
+		return nil, nil, err
+	}
+
+	participants := []sessionParticipantInfo{}
+	if err := s.db.Model(&models.SessionParticipant{}).
+		Select("session_participants.*, users.first_name, users.last_name, users.username").
+		Joins("LEFT JOIN users ON users.id = session_participants.user_id").
+		Where("session_participants.session_id = ?", sessionId).
+		Order("session_participants.joined_at ASC").
+		Scan(&participants).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return &session, participants, nil
+}
+
+func (r *RoomHander) GetRoomStats(ctx *gin.Context) {
+	roomId := ctx.Param("roomId")
+
+	stats, err := r.server.GetRoomStats(roomId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	live, err := r.server.GetLiveMeetingSession(roomId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":     "Fetched room stats successfully",
+		"stats":       stats,
+		"liveSession": live,
+	})
+}
+
+func (r *RoomHander) ListMeetingSessions(ctx *gin.Context) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	sessions, total, err := r.server.ListMeetingSessions(ctx.Param("roomId"), limit, (page-1)*limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":  "Fetched meeting sessions successfully",
+		"sessions": sessions,
+		"page":     page,
+		"limit":    limit,
+		"total":    total,
+	})
+}
+
+func (r *RoomHander) GetMeetingSession(ctx *gin.Context) {
+	session, participants, err := r.server.GetMeetingSession(ctx.Param("roomId"), ctx.Param("sessionId"))
+	if errors.Is(err, ErrMeetingSessionNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":      "Fetched meeting session successfully",
+		"session":      session,
+		"participants": participants,
+	})
+}
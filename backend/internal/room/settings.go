@@ -0,0 +1,129 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"video-chat/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrRoomPasswordMissing = errors.New("a password is needed to protect the room")
+
+type updateRoomSettingsRequest struct {
+	Name             *string `json:"name" binding:"omitempty,min=1,max=100"`
+	Description      *string `json:"description" binding:"omitempty,max=500"`
+	MaxUsers         *int    `json:"maxUsers" binding:"omitempty,min=2,max=50"`
+	AllowChat        *bool   `json:"allow_chat"`
+	AllowScreenShare *bool   `json:"allow_screen_share"`
+	MuteOnEntry      *bool   `json:"mute_on_entry"`
+	RequirePassword  *bool   `json:"require_password"`
+	Password         *string `json:"password" binding:"omitempty,min=4,max=72"`
+}
+
+// UpdateRoomSettings applies the fields set in req. Turning the password off
+// also forgets the stored password.
+func (s *RoomService) UpdateRoomSettings(roomId string, req updateRoomSettingsRequest) (*models.Room, error) {
+	room, err := s.GetRoom(roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		updates["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.MaxUsers != nil {
+		updates["max_users"] = *req.MaxUsers
+	}
+	if req.AllowChat != nil {
+		updates["allow_chat"] = *req.AllowChat
+	}
+	if req.AllowScreenShare != nil {
+		updates["allow_screen_share"] = *req.AllowScreenShare
+	}
+	if req.MuteOnEntry != nil {
+		updates["mute_on_entry"] = *req.MuteOnEntry
+	}
+
+	requirePassword := room.RequirePassword
+	if req.RequirePassword != nil {
+		requirePassword = *req.RequirePassword
+		updates["require_password"] = requirePassword
+	}
+	switch {
+	case !requirePassword:
+		if room.Password != "" {
+			updates["password"] = nil
+		}
+	case req.Password != nil:
+		hash, err := hashRoomPassword(*req.Password)
+		if err != nil {
+			return nil, err
+		}
+		updates["password"] = hash
+	case room.Password == "":
+		return nil, ErrRoomPasswordMissing
+	}
+
+	if len(updates) == 0 {
+		return room, nil
+	}
+
+	if err := s.db.Model(room).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	return s.GetRoom(roomId)
+}
+
+func (r *RoomHander) UpdateRoomSettings(ctx *gin.Context) {
+	roomId := ctx.Param("roomId")
+
+	var req updateRoomSettingsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Room name can't be empty"})
+		return
+	}
+
+	// Nobody gets disconnected by a lower limit, so it can't drop below
+	// the people already in the meeting
+	if req.MaxUsers != nil {
+		if connected := r.hub.RoomUserCount(roomId); *req.MaxUsers < connected {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%d users are connected to the room right now", connected)})
+			return
+		}
+	}
+
+	room, err := r.server.UpdateRoomSettings(roomId, req)
+	if errors.Is(err, ErrRoomNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrRoomPasswordMissing) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to update settings of room %s: %v\n", roomId, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update room settings"})
+		return
+	}
+
+	r.hub.PublishRoomSettings(room)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Room settings updated successfully",
+		"room":    room,
+	})
+}
@@ -0,0 +1,49 @@
+package room
+
+import (
+	"net/http"
+	"video-chat/internal/models"
+	"video-chat/internal/websockets"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RecordMeetingTelemetry adds a participant's call quality totals to their
+// open stretch of the meeting. Averages are weighted by the number of
+// reports, in case the stretch already holds some from another instance.
+func (s *RoomService) RecordMeetingTelemetry(roomId, sessionId, userId string, totals websockets.TelemetryTotals) error {
+	if totals.Reports == 0 {
+		return nil
+	}
+
+	weighted := func(column string, value float64) any {
+		return gorm.Expr("("+column+" * stats_reports + ?) / (stats_reports + ?)", value*float64(totals.Reports), totals.Reports)
+	}
+
+	return s.db.Model(&models.SessionParticipant{}).
+		Where("session_id = ? AND user_id = ? AND left_at IS NULL", sessionId, userId).
+		Updates(map[string]any{
+			"bytes_transferred": gorm.Expr("bytes_transferred + ?", totals.Bytes),
+			"avg_rtt":           weighted("avg_rtt", totals.AvgRTT),
+			"avg_jitter":        weighted("avg_jitter", totals.AvgJitter),
+			"avg_packet_loss":   weighted("avg_packet_loss", totals.AvgPacketLoss),
+			"avg_quality_score": weighted("avg_quality_score", totals.AvgScore),
+			"stats_reports":     gorm.Expr("stats_reports + ?", totals.Reports),
+		}).Error
+}
+
+// GetMeetingQuality shows the call quality of the live meeting, for the
+// participants connected to this instance
+func (r *RoomHander) GetMeetingQuality(ctx *gin.Context) {
+	quality := r.hub.MeetingQuality(ctx.Param("roomId"))
+	if quality == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "No meeting in progress"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Fetched meeting quality successfully",
+		"quality": quality,
+	})
+}
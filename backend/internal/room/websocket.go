@@ -0,0 +1,90 @@
+package room
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"video-chat/internal/models"
+	"video-chat/internal/websockets"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ServeWebsocket upgrades the request and joins the caller to the room's live
+// session once membership and capacity checks pass
+func (r *RoomHander) ServeWebsocket(ctx *gin.Context) {
+	roomId := ctx.Param("roomId")
+	userId := ctx.GetString("userId")
+
+	conn, err := websockets.Upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		log.Printf("error upgrading to websocket: %v", err)
+		return
+	}
+
+	room, roomMember, err := r.server.AuthorizeRoomConnection(userId, roomId)
+	switch {
+	case errors.Is(err, ErrRoomNotFound):
+		websockets.RejectConnection(conn, websockets.CloseRoomNotFound, err.Error())
+		return
+	case errors.Is(err, ErrRoomPasswordRequired):
+		websockets.RejectConnection(conn, websockets.ClosePasswordRequired, err.Error())
+		return
+	case errors.Is(err, ErrNotRoomMember), errors.Is(err, ErrUserBanned):
+		websockets.RejectConnection(conn, websockets.CloseForbidden, err.Error())
+		return
+	case err != nil:
+		log.Printf("error authorizing websocket for room %s: %v", roomId, err)
+		websockets.RejectConnection(conn, websockets.CloseInternalError, "unable to join room")
+		return
+	}
+
+	// Members of protected rooms need to have entered the password first
+	if !r.hasRoomPass(room, roomMember) {
+		websockets.RejectConnection(conn, websockets.ClosePasswordRequired, ErrRoomPasswordRequired.Error())
+		return
+	}
+
+	// Moderators can always get into a locked meeting
+	if !HasPermission(roomMember.Role, PermModerateChat) && r.meetingLocked(room.ID, userId) {
+		websockets.RejectConnection(conn, websockets.CloseRoomLocked, "meeting is locked")
+		return
+	}
+
+	userName, err := r.server.GetUserDisplayName(userId)
+	if err != nil {
+		log.Printf("error loading name of user %s: %v", userId, err)
+		websockets.RejectConnection(conn, websockets.CloseInternalError, "unable to join room")
+		return
+	}
+
+	client := websockets.NewClient(r.hub, conn, roomId, userId, userName)
+	client.SetRole(roomMember.Role)
+	r.startSession(ctx, conn, room, userId, client)
+}
+
+// meetingLocked reports whether a locked meeting keeps the user out.
+// Participants who are only reconnecting are let back in.
+func (r *RoomHander) meetingLocked(roomId, userId string) bool {
+	return r.hub.IsRoomLocked(roomId) && !r.hub.IsUserInRoom(roomId, userId)
+}
+
+// startSession seats an authorized client in the room and starts its pumps
+func (r *RoomHander) startSession(ctx *gin.Context, conn *websocket.Conn, room *models.Room, userId string, client *websockets.Client) {
+	// Reconnecting users already hold a seat in the room
+	if room.MaxUsers > 0 && !r.hub.IsUserInRoom(room.ID, userId) && r.hub.RoomUserCount(room.ID) >= room.MaxUsers {
+		websockets.RejectConnection(conn, websockets.CloseRoomFull, "room is full")
+		return
+	}
+
+	// Reconnecting clients pass the last sequence number they saw
+	if lastSeq, err := strconv.ParseUint(ctx.Query("lastSeq"), 10, 64); err == nil {
+		client.ResumeFrom(lastSeq)
+	}
+	r.hub.Register(client)
+	r.hub.SendRoomSettings(client, room)
+
+	go client.WritePump()
+	go client.ReadPump()
+}
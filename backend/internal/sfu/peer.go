@@ -0,0 +1,196 @@
+package sfu
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// maxPendingCandidates bounds the candidates held until the first answer
+const maxPendingCandidates = 64
+
+var ErrNotAnAnswer = errors.New("the relay only accepts answers to its offers")
+
+// peer is one participant connection to the relay, publishing its own
+// tracks and subscribed to everyone else's
+type peer struct {
+	id     string
+	userID string
+	room   *room
+	pc     *webrtc.PeerConnection
+	signal Signaler
+
+	// Guards the negotiation, only one offer is ever outstanding
+	mutex sync.Mutex
+
+	// Set when the room changed while an offer was unanswered, another
+	// offer follows the answer
+	pending bool
+
+	// Candidates that arrived before the first answer
+	candidates []webrtc.ICECandidateInit
+
+	offered bool
+	closed  bool
+}
+
+func newPeer(r *room, userID, id string, pc *webrtc.PeerConnection, signal Signaler) *peer {
+	return &peer{
+		id:     id,
+		userID: userID,
+		room:   r,
+		pc:     pc,
+		signal: signal,
+	}
+}
+
+// negotiate brings the peer's subscriptions in line with the room's tracks
+// and offers the result. While an offer is unanswered the change waits for
+// the answer instead.
+func (p *peer) negotiate() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return
+	}
+	if p.pc.SignalingState() != webrtc.SignalingStateStable {
+		p.pending = true
+		return
+	}
+	p.pending = false
+
+	tracks := p.room.subscriptions(p)
+	changed := false
+
+	for _, sender := range p.pc.GetSenders() {
+		local := sender.Track()
+		if local == nil {
+			continue
+		}
+		if _, ok := tracks[local.ID()]; ok {
+			delete(tracks, local.ID())
+			continue
+		}
+		if err := p.pc.RemoveTrack(sender); err != nil {
+			log.Printf("error unsubscribing peer %s in room %s: %v", p.id, p.room.id, err)
+		}
+		changed = true
+	}
+
+	for _, t := range tracks {
+		sender, err := p.pc.AddTrack(t.local)
+		if err != nil {
+			log.Printf("error subscribing peer %s in room %s: %v", p.id, p.room.id, err)
+			continue
+		}
+		go p.readRTCP(sender, t)
+		changed = true
+	}
+
+	if p.offered && !changed {
+		return
+	}
+
+	offer, err := p.pc.CreateOffer(nil)
+	if err == nil {
+		err = p.pc.SetLocalDescription(offer)
+	}
+	if err != nil {
+		log.Printf("error offering to peer %s in room %s: %v", p.id, p.room.id, err)
+		return
+	}
+	p.offered = true
+	p.signal.Offer(offer)
+}
+
+// answer completes the outstanding offer, then makes the next one if the
+// room changed in the meantime
+func (p *peer) answer(answer webrtc.SessionDescription) error {
+	if answer.Type != webrtc.SDPTypeAnswer {
+		return ErrNotAnAnswer
+	}
+
+	p.mutex.Lock()
+	err := p.pc.SetRemoteDescription(answer)
+	if err == nil {
+		for _, candidate := range p.candidates {
+			if err := p.pc.AddICECandidate(candidate); err != nil {
+				log.Printf("error adding candidate of peer %s in room %s: %v", p.id, p.room.id, err)
+			}
+		}
+		p.candidates = nil
+	}
+	pending := p.pending
+	p.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if pending {
+		p.negotiate()
+	}
+	return nil
+}
+
+// addCandidate adds a remote ICE candidate, holding it until the first
+// answer is in
+func (p *peer) addCandidate(candidate webrtc.ICECandidateInit) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.pc.RemoteDescription() == nil {
+		if len(p.candidates) < maxPendingCandidates {
+			p.candidates = append(p.candidates, candidate)
+		}
+		return nil
+	}
+	return p.pc.AddICECandidate(candidate)
+}
+
+// sendCandidate trickles a local ICE candidate to the participant. Holding
+// the negotiation lock keeps candidates from overtaking the offer they
+// belong to.
+func (p *peer) sendCandidate(candidate *webrtc.ICECandidate) {
+	if candidate == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.closed {
+		p.signal.Candidate(candidate.ToJSON())
+	}
+}
+
+// readRTCP drains a subscription's RTCP, which keeps the interceptors
+// answering NACKs, and passes keyframe requests on to the publisher
+func (p *peer) readRTCP(sender *webrtc.RTPSender, t *track) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		for _, packet := range packets {
+			switch packet.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				t.requestKeyframe()
+			}
+		}
+	}
+}
+
+func (p *peer) close() {
+	p.mutex.Lock()
+	p.closed = true
+	p.mutex.Unlock()
+
+	if err := p.pc.Close(); err != nil {
+		log.Printf("error closing peer %s in room %s: %v", p.id, p.room.id, err)
+	}
+}
@@ -0,0 +1,177 @@
+package sfu
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// rtpBufferSize fits any RTP packet sent within a typical MTU
+	rtpBufferSize = 1500
+
+	// keyframeInterval throttles keyframe requests forwarded to a
+	// publisher, subscribers joining together only need one
+	keyframeInterval = 500 * time.Millisecond
+)
+
+// track is a published track as it is forwarded to the room
+type track struct {
+	local     *webrtc.TrackLocalStaticRTP
+	remote    *webrtc.TrackRemote
+	publisher *peer
+
+	// Unix nanoseconds of the last keyframe request sent to the publisher
+	keyframeAt atomic.Int64
+}
+
+// requestKeyframe asks the publisher for a keyframe so a subscriber can
+// start decoding or recover from loss
+func (t *track) requestKeyframe() {
+	if t.remote.Kind() != webrtc.RTPCodecTypeVideo {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	last := t.keyframeAt.Load()
+	if now-last < int64(keyframeInterval) || !t.keyframeAt.CompareAndSwap(last, now) {
+		return
+	}
+
+	t.publisher.pc.WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{MediaSSRC: uint32(t.remote.SSRC())},
+	})
+}
+
+// room is the relay's view of one meeting: who is connected and what they
+// publish
+type room struct {
+	id string
+
+	mutex  sync.Mutex
+	peers  map[string]*peer
+	tracks map[string]*track // Keyed by forwarded track ID
+}
+
+func newRoom(id string) *room {
+	return &room{
+		id:     id,
+		peers:  make(map[string]*peer),
+		tracks: make(map[string]*track),
+	}
+}
+
+// add adds a peer, returning the one it replaces under the same ID
+func (r *room) add(p *peer) *peer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	previous := r.peers[p.id]
+	r.peers[p.id] = p
+	return previous
+}
+
+// remove drops the peer if it is still the room's peer under its ID, and
+// reports whether the room is empty now
+func (r *room) remove(p *peer) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.peers[p.id] == p {
+		delete(r.peers, p.id)
+	}
+	return len(r.peers) == 0
+}
+
+func (r *room) peer(id string) *peer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.peers[id]
+}
+
+// subscriptions returns the tracks the peer should be receiving, which is
+// everything published in the room except its own
+func (r *room) subscriptions(p *peer) map[string]*track {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	tracks := make(map[string]*track, len(r.tracks))
+	for id, t := range r.tracks {
+		if t.publisher != p {
+			tracks[id] = t
+		}
+	}
+	return tracks
+}
+
+// renegotiate brings every peer other than except up to date with the room's
+// tracks
+func (r *room) renegotiate(except *peer) {
+	r.mutex.Lock()
+	peers := make([]*peer, 0, len(r.peers))
+	for _, p := range r.peers {
+		if p != except {
+			peers = append(peers, p)
+		}
+	}
+	r.mutex.Unlock()
+
+	for _, p := range peers {
+		p.negotiate()
+	}
+}
+
+// forward relays a track published by p to the rest of the room until the
+// publisher stops sending it. The forwarded track keeps the publisher's
+// user ID as its stream ID, so subscribers know whose media it is.
+func (r *room) forward(p *peer, remote *webrtc.TrackRemote) {
+	local, err := webrtc.NewTrackLocalStaticRTP(
+		remote.Codec().RTPCodecCapability,
+		remote.Kind().String()+"-"+p.id,
+		p.userID,
+	)
+	if err != nil {
+		log.Printf("error forwarding %s track in room %s: %v", remote.Kind(), r.id, err)
+		return
+	}
+	t := &track{local: local, remote: remote, publisher: p}
+
+	r.mutex.Lock()
+	_, taken := r.tracks[local.ID()]
+	if r.peers[p.id] != p || taken {
+		r.mutex.Unlock()
+		return
+	}
+	r.tracks[local.ID()] = t
+	r.mutex.Unlock()
+
+	r.renegotiate(p)
+
+	defer func() {
+		r.mutex.Lock()
+		delete(r.tracks, local.ID())
+		r.mutex.Unlock()
+
+		r.renegotiate(p)
+	}()
+
+	buf := make([]byte, rtpBufferSize)
+	for {
+		n, _, err := remote.Read(buf)
+		if err != nil {
+			return
+		}
+
+		// Writes to subscribers still negotiating fail with a closed pipe
+		if _, err := local.Write(buf[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+	}
+}
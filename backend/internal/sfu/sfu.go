@@ -0,0 +1,230 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"video-chat/internal/config"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+)
+
+var ErrPeerNotFound = errors.New("not connected to the media relay")
+
+// Signaler carries the relay's half of the negotiation to a participant.
+// The relay always makes the offers, the participant only answers them and
+// trickles its ICE candidates back.
+type Signaler interface {
+	Offer(offer webrtc.SessionDescription)
+	Candidate(candidate webrtc.ICECandidateInit)
+}
+
+// Options configures the peer connections made by the relay
+type Options struct {
+	// ICE servers of the relay itself, only needed behind NAT
+	ICEServers []webrtc.ICEServer
+
+	// Ports, addresses and interfaces used for media. See Loopback for the
+	// settings tests run with.
+	SettingEngine webrtc.SettingEngine
+}
+
+// SFU is a selective forwarding unit. Every participant publishes their
+// audio and video to it once and it forwards them to everyone else in the
+// room, so uplink bandwidth no longer grows with the size of the meeting.
+// Rooms live in the memory of one process, participants can only reach
+// each other through the same SFU.
+type SFU struct {
+	api    *webrtc.API
+	config webrtc.Configuration
+
+	// Rooms with at least one peer, keyed by room ID
+	rooms map[string]*room
+	mutex sync.Mutex
+}
+
+// New creates a relay with pion's default codecs and interceptors, which
+// answer NACKs from subscribers and send receiver reports to publishers
+func New(opts Options) (*SFU, error) {
+	media := &webrtc.MediaEngine{}
+	if err := media.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+
+	interceptors := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(media, interceptors); err != nil {
+		return nil, err
+	}
+
+	api := webrtc.NewAPI(
+		webrtc.WithMediaEngine(media),
+		webrtc.WithInterceptorRegistry(interceptors),
+		webrtc.WithSettingEngine(opts.SettingEngine),
+	)
+
+	return &SFU{
+		api:    api,
+		config: webrtc.Configuration{ICEServers: opts.ICEServers},
+		rooms:  make(map[string]*room),
+	}, nil
+}
+
+// FromConfig creates a relay from the SFU_ environment settings.
+// SFU_ICE_SERVERS is a comma separated list of STUN URLs, SFU_PUBLIC_IP is
+// announced instead of the host's own address and SFU_UDP_PORT_MIN and
+// SFU_UDP_PORT_MAX limit the ports media is received on.
+func FromConfig(cfg *config.Config) (*SFU, error) {
+	var opts Options
+	for _, url := range strings.Split(cfg.SFU_ICE_SERVERS, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			opts.ICEServers = append(opts.ICEServers, webrtc.ICEServer{URLs: []string{url}})
+		}
+	}
+
+	if cfg.SFU_PUBLIC_IP != "" {
+		opts.SettingEngine.SetNAT1To1IPs([]string{cfg.SFU_PUBLIC_IP}, webrtc.ICECandidateTypeHost)
+	}
+
+	if cfg.SFU_UDP_PORT_MIN != "" || cfg.SFU_UDP_PORT_MAX != "" {
+		portMin, errMin := strconv.ParseUint(cfg.SFU_UDP_PORT_MIN, 10, 16)
+		portMax, errMax := strconv.ParseUint(cfg.SFU_UDP_PORT_MAX, 10, 16)
+		if errMin != nil || errMax != nil {
+			return nil, fmt.Errorf("invalid SFU UDP port range %q-%q", cfg.SFU_UDP_PORT_MIN, cfg.SFU_UDP_PORT_MAX)
+		}
+		if err := opts.SettingEngine.SetEphemeralUDPPortRange(uint16(portMin), uint16(portMax)); err != nil {
+			return nil, err
+		}
+	}
+
+	return New(opts)
+}
+
+// Loopback returns settings that keep ICE on the loopback interface, so the
+// relay and pion clients can exchange media without any network
+func Loopback() webrtc.SettingEngine {
+	var settings webrtc.SettingEngine
+	settings.SetIncludeLoopbackCandidate(true)
+	settings.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	settings.SetInterfaceFilter(func(name string) bool {
+		return name == "lo" || strings.HasPrefix(name, "lo0")
+	})
+	return settings
+}
+
+// Join connects a participant's connection to the room's relay and sends
+// it the first offer, which receives one audio and one video track from it
+// and carries everything already published in the room. Joining again with
+// the same peer ID replaces the previous connection.
+func (s *SFU) Join(roomID, userID, peerID string, signal Signaler) error {
+	pc, err := s.api.NewPeerConnection(s.config)
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if _, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		}); err != nil {
+			pc.Close()
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	r, ok := s.rooms[roomID]
+	if !ok {
+		r = newRoom(roomID)
+		s.rooms[roomID] = r
+	}
+	p := newPeer(r, userID, peerID, pc, signal)
+	previous := r.add(p)
+	s.mutex.Unlock()
+
+	if previous != nil {
+		previous.close()
+	}
+
+	pc.OnICECandidate(p.sendCandidate)
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		r.forward(p, remote)
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			s.remove(p)
+		}
+	})
+
+	p.negotiate()
+	return nil
+}
+
+// Answer applies the participant's answer to the relay's last offer
+func (s *SFU) Answer(roomID, peerID string, answer webrtc.SessionDescription) error {
+	p := s.peer(roomID, peerID)
+	if p == nil {
+		return ErrPeerNotFound
+	}
+	return p.answer(answer)
+}
+
+// AddCandidate adds an ICE candidate trickled by the participant
+func (s *SFU) AddCandidate(roomID, peerID string, candidate webrtc.ICECandidateInit) error {
+	p := s.peer(roomID, peerID)
+	if p == nil {
+		return ErrPeerNotFound
+	}
+	return p.addCandidate(candidate)
+}
+
+// Leave disconnects a participant's connection from the relay. Whatever it
+// published is taken away from the others.
+func (s *SFU) Leave(roomID, peerID string) {
+	if p := s.peer(roomID, peerID); p != nil {
+		s.remove(p)
+	}
+}
+
+// LeaveAll disconnects every participant from the relay, in every room.
+// The relay can be joined again afterwards.
+func (s *SFU) LeaveAll() {
+	var peers []*peer
+	s.mutex.Lock()
+	for _, r := range s.rooms {
+		r.mutex.Lock()
+		for _, p := range r.peers {
+			peers = append(peers, p)
+		}
+		r.mutex.Unlock()
+	}
+	s.mutex.Unlock()
+
+	for _, p := range peers {
+		s.remove(p)
+	}
+}
+
+func (s *SFU) peer(roomID, peerID string) *peer {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r, ok := s.rooms[roomID]
+	if !ok {
+		return nil
+	}
+	return r.peer(peerID)
+}
+
+// remove drops the peer, if it is still the room's peer under its ID, and
+// forgets the room once nobody is left in it
+func (s *SFU) remove(p *peer) {
+	s.mutex.Lock()
+	if p.room.remove(p) && s.rooms[p.room.id] == p.room {
+		delete(s.rooms, p.room.id)
+	}
+	s.mutex.Unlock()
+
+	p.close()
+}
@@ -0,0 +1,363 @@
+package sfu
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// testClient stands in for a participant's browser. It publishes an audio
+// and a video track to the relay, answers its offers and counts the packets
+// it receives per forwarded stream.
+type testClient struct {
+	relay  *SFU
+	roomID string
+	userID string
+	peerID string
+	pc     *webrtc.PeerConnection
+
+	offers atomic.Int32
+
+	mutex    sync.Mutex
+	received map[string]*atomic.Int64 // Keyed by "<user ID>/<kind>"
+
+	// Stops the client's goroutines, which the test waits for
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newTestRelay(t *testing.T) *SFU {
+	t.Helper()
+
+	relay, err := New(Options{SettingEngine: Loopback()})
+	if err != nil {
+		t.Fatalf("error creating relay: %v", err)
+	}
+	return relay
+}
+
+// join connects a client for userID to the relay's room under peerID
+func join(t *testing.T, relay *SFU, userID, peerID string) *testClient {
+	t.Helper()
+
+	media := &webrtc.MediaEngine{}
+	if err := media.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(media), webrtc.WithSettingEngine(Loopback()))
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("error creating client connection: %v", err)
+	}
+
+	c := &testClient{
+		relay:    relay,
+		roomID:   "room",
+		userID:   userID,
+		peerID:   peerID,
+		pc:       pc,
+		received: make(map[string]*atomic.Int64),
+		done:     make(chan struct{}),
+	}
+	t.Cleanup(c.close)
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate != nil {
+			relay.AddCandidate(c.roomID, peerID, candidate.ToJSON())
+		}
+	})
+	pc.OnTrack(c.receive)
+
+	audio, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, "audio", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}, "video", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, track := range []*webrtc.TrackLocalStaticRTP{audio, video} {
+		if _, err := pc.AddTrack(track); err != nil {
+			t.Fatalf("error publishing %s: %v", track.Kind(), err)
+		}
+		c.wg.Add(1)
+		go c.publish(track)
+	}
+
+	if err := relay.Join(c.roomID, userID, peerID, c); err != nil {
+		t.Fatalf("error joining relay: %v", err)
+	}
+	return c
+}
+
+// Offer answers the relay's offer the way a browser would
+func (c *testClient) Offer(offer webrtc.SessionDescription) {
+	c.offers.Add(1)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		if err := c.pc.SetRemoteDescription(offer); err != nil {
+			return
+		}
+		answer, err := c.pc.CreateAnswer(nil)
+		if err != nil {
+			return
+		}
+		if err := c.pc.SetLocalDescription(answer); err != nil {
+			return
+		}
+		c.relay.Answer(c.roomID, c.peerID, answer)
+	}()
+}
+
+// Candidate adds the relay's candidate once the offer it belongs to is in
+func (c *testClient) Candidate(candidate webrtc.ICECandidateInit) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		for c.pc.RemoteDescription() == nil {
+			select {
+			case <-c.done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+		c.pc.AddICECandidate(candidate)
+	}()
+}
+
+// publish sends a packet every 20ms, like a microphone or camera would
+func (c *testClient) publish(track *webrtc.TrackLocalStaticRTP) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	packet := &rtp.Packet{Header: rtp.Header{Version: 2}, Payload: []byte{0x10, 0x01, 0x02, 0x03}}
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		packet.SequenceNumber++
+		packet.Timestamp += 960
+		track.WriteRTP(packet)
+	}
+}
+
+func (c *testClient) receive(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+	key := remote.StreamID() + "/" + remote.Kind().String()
+	c.mutex.Lock()
+	count, ok := c.received[key]
+	if !ok {
+		count = &atomic.Int64{}
+		c.received[key] = count
+	}
+	c.mutex.Unlock()
+
+	buf := make([]byte, rtpBufferSize)
+	for {
+		if _, _, err := remote.Read(buf); err != nil {
+			return
+		}
+		count.Add(1)
+	}
+}
+
+// packets returns how many packets arrived from the user's track of kind
+func (c *testClient) packets(userID, kind string) int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if count, ok := c.received[userID+"/"+kind]; ok {
+		return count.Load()
+	}
+	return 0
+}
+
+func (c *testClient) close() {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	close(c.done)
+	c.pc.Close()
+	c.wg.Wait()
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// waitForMedia waits until c receives both of the user's tracks
+func waitForMedia(t *testing.T, c *testClient, userID string) {
+	t.Helper()
+
+	eventually(t, c.peerID+" to receive "+userID, func() bool {
+		return c.packets(userID, "audio") > 0 && c.packets(userID, "video") > 0
+	})
+}
+
+// forwarded returns the IDs of the tracks the relay forwards in the room
+func forwarded(relay *SFU, roomID string) map[string]bool {
+	relay.mutex.Lock()
+	r := relay.rooms[roomID]
+	relay.mutex.Unlock()
+
+	ids := make(map[string]bool)
+	if r == nil {
+		return ids
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for id := range r.tracks {
+		ids[id] = true
+	}
+	return ids
+}
+
+func TestPublishedTracksReachOthers(t *testing.T) {
+	relay := newTestRelay(t)
+	alice := join(t, relay, "alice", "alice-1")
+	bob := join(t, relay, "bob", "bob-1")
+	carol := join(t, relay, "carol", "carol-1")
+
+	waitForMedia(t, alice, "bob")
+	waitForMedia(t, alice, "carol")
+	waitForMedia(t, bob, "alice")
+	waitForMedia(t, bob, "carol")
+	waitForMedia(t, carol, "alice")
+	waitForMedia(t, carol, "bob")
+
+	for _, c := range []*testClient{alice, bob, carol} {
+		if c.packets(c.userID, "audio") > 0 || c.packets(c.userID, "video") > 0 {
+			t.Errorf("%s received their own media back", c.userID)
+		}
+	}
+}
+
+func TestLeaveRemovesTracks(t *testing.T) {
+	relay := newTestRelay(t)
+	alice := join(t, relay, "alice", "alice-1")
+	bob := join(t, relay, "bob", "bob-1")
+	waitForMedia(t, alice, "bob")
+	waitForMedia(t, bob, "alice")
+
+	offers := alice.offers.Load()
+	relay.Leave("room", "bob-1")
+
+	if relay.peer("room", "bob-1") != nil {
+		t.Fatal("bob is still connected to the relay after leaving")
+	}
+	eventually(t, "bob's tracks to be taken away", func() bool {
+		ids := forwarded(relay, "room")
+		return !ids["audio-bob-1"] && !ids["video-bob-1"]
+	})
+	eventually(t, "alice to be offered the change", func() bool {
+		return alice.offers.Load() > offers
+	})
+
+	eventually(t, "alice to be unsubscribed from bob", func() bool {
+		p := relay.peer("room", "alice-1")
+		for _, sender := range p.pc.GetSenders() {
+			if sender.Track() != nil {
+				return false
+			}
+		}
+		return true
+	})
+
+	// The last one to leave takes the room with them
+	relay.Leave("room", "alice-1")
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+	if _, ok := relay.rooms["room"]; ok {
+		t.Error("relay kept the room after everyone left")
+	}
+}
+
+func TestLeaveAll(t *testing.T) {
+	relay := newTestRelay(t)
+	alice := join(t, relay, "alice", "alice-1")
+	bob := join(t, relay, "bob", "bob-1")
+	waitForMedia(t, alice, "bob")
+	waitForMedia(t, bob, "alice")
+
+	relay.LeaveAll()
+
+	if relay.peer("room", "alice-1") != nil || relay.peer("room", "bob-1") != nil {
+		t.Fatal("participants are still connected to the relay")
+	}
+	relay.mutex.Lock()
+	rooms := len(relay.rooms)
+	relay.mutex.Unlock()
+	if rooms != 0 {
+		t.Errorf("relay kept %d rooms after everyone was disconnected", rooms)
+	}
+
+	// The relay takes participants again
+	carol := join(t, relay, "carol", "carol-1")
+	dave := join(t, relay, "dave", "dave-1")
+	waitForMedia(t, carol, "dave")
+	waitForMedia(t, dave, "carol")
+}
+
+func TestJoinReplacesPeer(t *testing.T) {
+	relay := newTestRelay(t)
+	first := join(t, relay, "alice", "alice-1")
+	bob := join(t, relay, "bob", "bob-1")
+	waitForMedia(t, bob, "alice")
+
+	replaced := relay.peer("room", "alice-1")
+	second := join(t, relay, "alice", "alice-1")
+
+	if p := relay.peer("room", "alice-1"); p == replaced || p.signal != second {
+		t.Fatal("joining again didn't replace the first connection")
+	}
+	eventually(t, "the first connection to be closed", func() bool {
+		return replaced.pc.ConnectionState() == webrtc.PeerConnectionStateClosed
+	})
+	first.close()
+
+	// Media from the new connection is what bob receives from now on
+	current := relay.peer("room", "alice-1")
+	eventually(t, "alice's new connection to publish", func() bool {
+		relay.mutex.Lock()
+		r := relay.rooms["room"]
+		relay.mutex.Unlock()
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return r.tracks["audio-alice-1"] != nil && r.tracks["audio-alice-1"].publisher == current &&
+			r.tracks["video-alice-1"] != nil && r.tracks["video-alice-1"].publisher == current
+	})
+	waitForMedia(t, second, "bob")
+	before := bob.packets("alice", "video")
+	eventually(t, "bob to receive alice's new connection", func() bool {
+		return bob.packets("alice", "video") > before+10
+	})
+
+	relay.mutex.Lock()
+	peers := len(relay.rooms["room"].peers)
+	relay.mutex.Unlock()
+	if peers != 2 {
+		t.Errorf("room has %d peers, want 2", peers)
+	}
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// countAttempt adds an attempt to a counter in one step, starting its window
+// on the first one
+var countAttempt = redis.NewScript(`
+local attempts = redis.call('INCR', KEYS[1])
+if attempts == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return attempts
+`)
+
+// CountAttempt counts an attempt against key, which expires window after the
+// first one, and returns the attempts made so far. Counting before checking
+// means concurrent guesses can't all slip in under the limit.
+func CountAttempt(ctx context.Context, client *redis.Client, key string, window time.Duration) (int64, error) {
+	return countAttempt.Run(ctx, client, []string{key}, window.Milliseconds()).Int64()
+}
@@ -2,6 +2,9 @@ package utils
 
 import (
 	// "encoding/json"
+	crand "crypto/rand"
+	"encoding/base64"
+	"math/big"
 	"math/rand/v2"
 	"os"
 	"strconv"
@@ -15,14 +18,28 @@ func GetEnvOrDefaultValue(key, defaultValue string) string {
 	return defaultValue
 }
 
+// GenerateOTP returns a 6 digit code from a cryptographically secure source
 func GenerateOTP() string {
 	otp := ""
 	for i := 0; i < 6; i++ {
-		otp += string(rune('0' + rand.IntN(10)))
+		n, err := crand.Int(crand.Reader, big.NewInt(10))
+		if err != nil {
+			panic("crypto/rand unavailable: " + err.Error())
+		}
+		otp += string(rune('0' + n.Int64()))
 	}
 	return otp
 }
 
+// GenerateToken returns a URL safe token made of n random bytes
+func GenerateToken(n int) string {
+	buf := make([]byte, n)
+	if _, err := crand.Read(buf); err != nil {
+		panic("crypto/rand unavailable: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
 func GenerateRandomBase36String(length int) string {
 	// Generate a random number and convert to base36
 	num := rand.Int64()
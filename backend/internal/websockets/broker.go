@@ -0,0 +1,284 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	roomChannelPrefix     = "ws:room:"
+	userChannelPrefix     = "ws:user:"
+	instanceChannelPrefix = "ws:instance:"
+	presenceKeyPrefix = "ws:present:"
+
+	// presenceTTL is how long an instance's presence outlives it when it
+	// stops refreshing it, after a crash for instance. It is refreshed every
+	// third of that.
+	presenceTTL = 30 * time.Second
+)
+
+// envelope is the unit of fan-out between hub instances
+type envelope struct {
+	// Seq is the room sequence number, assigned when the envelope is published
+	Seq uint64 `json:"seq,omitempty"`
+	// Origin is the instance that published the envelope
+	Origin string `json:"origin"`
+	RoomID string `json:"roomId"`
+	// TargetUserID restricts delivery to one user's connections
+	TargetUserID string `json:"targetUserId,omitempty"`
+	// TargetClientID restricts delivery to a single connection
+	TargetClientID string `json:"targetClientId,omitempty"`
+	// SkipClientID suppresses the echo back to the sending connection
+	SkipClientID string `json:"skipClientId,omitempty"`
+	// Ephemeral events such as typing are neither numbered nor replayed
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	// Roles restricts delivery to connections whose member role is listed
+	Roles []string `json:"roles,omitempty"`
+	// Lobby settles the target user's waiting connections, see lobbyAdmit
+	Lobby string `json:"lobby,omitempty"`
+	// Evict disconnects the matching connections once the data is delivered
+	Evict bool `json:"evict,omitempty"`
+	// Speech carries an audioSample for the speaker detection instead of a
+	// message for the clients
+	Speech bool `json:"speech,omitempty"`
+	// Speaker is the active speaker announced by the data
+	Speaker string          `json:"speaker,omitempty"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Broker relays room traffic between backend replicas over Redis pub/sub so a
+// room spread across several instances behaves like a single room. Each
+// instance only subscribes to the rooms and users it has connections for.
+type Broker struct {
+	client     *redis.Client
+	pubsub     *redis.PubSub
+	instanceID string
+	ctx        context.Context
+}
+
+// NewBroker creates a broker on top of an existing Redis client
+func NewBroker(client *redis.Client) *Broker {
+	b := &Broker{
+		client:     client,
+		instanceID: uuid.NewString(),
+		ctx:        context.Background(),
+	}
+
+	// The instance's own channel keeps the connection in subscriber mode
+	// while there are no rooms to subscribe to
+	b.pubsub = client.Subscribe(b.ctx, instanceChannelPrefix+b.instanceID)
+	return b
+}
+
+func roomChannel(roomID string) string {
+	return roomChannelPrefix + roomID
+}
+
+func userChannel(userID string) string {
+	return userChannelPrefix + userID
+}
+
+// Publish sends an envelope to every instance subscribed to the room
+func (b *Broker) Publish(env envelope) error {
+	env.Origin = b.instanceID
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	if env.RoomID == "" {
+		return b.client.Publish(b.ctx, userChannel(env.TargetUserID), data).Err()
+	}
+	if env.sequenced() {
+		return b.publishSequenced(env.RoomID, data)
+	}
+	return b.client.Publish(b.ctx, roomChannel(env.RoomID), data).Err()
+}
+
+// presenceKey is the set of instances with participants in the room. Each
+// of them counts its users' connections in a hash under the same key
+// followed by ":" and its instance ID.
+func presenceKey(roomID string) string {
+	return presenceKeyPrefix + roomID
+}
+
+// livePresence is shared by the presence scripts. It calls fn with the
+// presence hash of every instance still refreshing it, forgetting the
+// instances whose hash expired.
+const livePresence = `
+local function eachInstance(fn)
+	for _, instance in ipairs(redis.call('SMEMBERS', KEYS[1])) do
+		local key = KEYS[1] .. ':' .. instance
+		if redis.call('EXISTS', key) == 1 then
+			fn(key)
+		else
+			redis.call('SREM', KEYS[1], instance)
+		end
+	end
+end
+
+local function userConnections(userID)
+	local total = 0
+	eachInstance(function(key)
+		total = total + tonumber(redis.call('HGET', key, userID) or 0)
+	end)
+	return total
+end
+`
+
+// changePresence adds ARGV[4] to the user's connections on the instance and
+// returns the user's connections across the live instances
+var changePresence = redis.NewScript(livePresence + `
+local key = KEYS[1] .. ':' .. ARGV[1]
+if redis.call('HINCRBY', key, ARGV[2], ARGV[4]) <= 0 then
+	redis.call('HDEL', key, ARGV[2])
+end
+redis.call('PEXPIRE', key, ARGV[3])
+redis.call('SADD', KEYS[1], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return userConnections(ARGV[2])
+`)
+
+// refreshPresence replaces the instance's presence with the users and
+// connection counts in ARGV[3...], in pairs
+var refreshPresence = redis.NewScript(`
+local key = KEYS[1] .. ':' .. ARGV[1]
+redis.call('DEL', key)
+if #ARGV < 3 then
+	return 0
+end
+for i = 3, #ARGV, 2 do
+	redis.call('HSET', key, ARGV[i], ARGV[i + 1])
+end
+redis.call('PEXPIRE', key, ARGV[2])
+redis.call('SADD', KEYS[1], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 0
+`)
+
+// countPresence returns the number of distinct users on the live instances
+var countPresence = redis.NewScript(livePresence + `
+local users, count = {}, 0
+eachInstance(function(key)
+	for _, userID in ipairs(redis.call('HKEYS', key)) do
+		if not users[userID] then
+			users[userID] = true
+			count = count + 1
+		end
+	end
+end)
+return count
+`)
+
+// userPresence returns the user's connections on the live instances
+var userPresence = redis.NewScript(livePresence + `
+return userConnections(ARGV[1])
+`)
+
+// holdLeaseScript takes the lease if it is free and renews it if the
+// instance holds it already
+var holdLeaseScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// holdLease reports whether this instance holds the lease under key for the
+// next ttl, taking it if nobody else does
+func (b *Broker) holdLease(key string, ttl time.Duration) (bool, error) {
+	held, err := holdLeaseScript.Run(b.ctx, b.client, []string{key}, b.instanceID, ttl.Milliseconds()).Int()
+	return held == 1, err
+}
+
+// AddPresence counts a new connection of the user to the room across all
+// instances and returns the user's total connection count
+func (b *Broker) AddPresence(roomID, userID string) (int64, error) {
+	return b.changePresence(roomID, userID, 1)
+}
+
+// RemovePresence drops one connection of the user and returns how many remain
+func (b *Broker) RemovePresence(roomID, userID string) (int64, error) {
+	return b.changePresence(roomID, userID, -1)
+}
+
+func (b *Broker) changePresence(roomID, userID string, by int) (int64, error) {
+	return changePresence.Run(b.ctx, b.client, []string{presenceKey(roomID)},
+		b.instanceID, userID, presenceTTL.Milliseconds(), by).Int64()
+}
+
+// RefreshPresence rewrites this instance's presence in the room from its
+// users' connection counts and keeps it alive for another presenceTTL.
+// Presence of an instance that stops refreshing it expires, so the users of
+// a crashed instance don't stay in the room forever.
+func (b *Broker) RefreshPresence(roomID string, users map[string]int) error {
+	args := make([]any, 0, 2+2*len(users))
+	args = append(args, b.instanceID, presenceTTL.Milliseconds())
+	for userID, count := range users {
+		args = append(args, userID, count)
+	}
+	return refreshPresence.Run(b.ctx, b.client, []string{presenceKey(roomID)}, args...).Err()
+}
+
+// PresentUsers returns the number of distinct users connected to the room
+// across all instances
+func (b *Broker) PresentUsers(roomID string) (int64, error) {
+	return countPresence.Run(b.ctx, b.client, []string{presenceKey(roomID)}).Int64()
+}
+
+// IsPresent reports whether the user has a connection to the room on any instance
+func (b *Broker) IsPresent(roomID, userID string) (bool, error) {
+	count, err := userPresence.Run(b.ctx, b.client, []string{presenceKey(roomID)}, userID).Int64()
+	return count > 0, err
+}
+
+// SubscribeRoom starts receiving the room's traffic on this instance
+func (b *Broker) SubscribeRoom(roomID string) error {
+	return b.pubsub.Subscribe(b.ctx, roomChannel(roomID))
+}
+
+// UnsubscribeRoom stops receiving the room's traffic once nobody is
+// connected to it here
+func (b *Broker) UnsubscribeRoom(roomID string) error {
+	return b.pubsub.Unsubscribe(b.ctx, roomChannel(roomID))
+}
+
+// SubscribeUser starts receiving what is sent to the user on this instance
+func (b *Broker) SubscribeUser(userID string) error {
+	return b.pubsub.Subscribe(b.ctx, userChannel(userID))
+}
+
+// UnsubscribeUser stops receiving what is sent to the user once their last
+// connection here is gone
+func (b *Broker) UnsubscribeUser(userID string) error {
+	return b.pubsub.Unsubscribe(b.ctx, userChannel(userID))
+}
+
+// Receive hands the envelopes of the subscribed rooms and users to deliver
+// until the subscription is closed
+func (b *Broker) Receive(deliver func(envelope)) {
+	defer b.pubsub.Close()
+
+	for msg := range b.pubsub.Channel() {
+		var env envelope
+		if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+			log.Printf("error unmarshaling envelope: %v", err)
+			continue
+		}
+
+		if env.RoomID == "" && strings.HasPrefix(msg.Channel, roomChannelPrefix) {
+			env.RoomID = strings.TrimPrefix(msg.Channel, roomChannelPrefix)
+		}
+		deliver(env)
+	}
+}
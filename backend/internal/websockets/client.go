@@ -1,11 +1,12 @@
 package websockets
 
 import (
-    "encoding/json"
     "log"
     "net/http"
+    "sync/atomic"
     "time"
 
+    "github.com/google/uuid"
     "github.com/gorilla/websocket"
 )
 
@@ -13,7 +14,7 @@ const (
     writeWait      = 10 * time.Second
     pongWait       = 60 * time.Second
     pingPeriod     = (pongWait * 9) / 10
-    maxMessageSize = 512
+    maxMessageSize = 64 * 1024 // SDP offers easily exceed a few KB
 )
 
 var (
@@ -30,20 +31,67 @@ var Upgrader = websocket.Upgrader{
     },
 }
 
+// Application close codes sent when a connection is refused after upgrade.
+// Browsers can't read the HTTP status of a failed handshake, so the reason is
+// delivered as a close frame instead.
+const (
+    ClosePasswordRequired = 4001
+    CloseForbidden        = 4003
+    CloseRoomNotFound     = 4004
+    CloseAlreadyMember    = 4008
+    CloseRoomFull         = 4009
+    CloseRoomLocked       = 4023
+    CloseInternalError    = 4500
+)
+
+// RejectConnection closes an upgraded connection with the given code and reason
+func RejectConnection(conn *websocket.Conn, code int, reason string) {
+    msg := websocket.FormatCloseMessage(code, reason)
+    conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+    conn.Close()
+}
+
 // Client represents a connected WebSocket client
 type Client struct {
     Hub      *Hub
+    room     *roomHub
+    id       string
     conn     *websocket.Conn
     send     chan []byte
     roomID   string
     userID   string
     userName string
+
+    // Last room sequence number delivered, owned by the room actor
+    lastSeq uint64
+
+    // Guests connect with a token instead of an account and only chat when
+    // the token allows it
+    guest     bool
+    guestChat bool
+
+    // Member role at connect time, owned by the room actor once registered
+    role string
+
+    // Set by the room actor when a moderator removed the client, its seat
+    // is given up right away instead of being held for a reconnect
+    evicted bool
+
+    // When the client's last stats report arrived, owned by the room actor
+    statsAt time.Time
+
+    // When the client's last audio level arrived, owned by the read pump
+    audioAt time.Time
+
+    // Set while the client waits in the lobby to be admitted
+    lobby atomic.Bool
 }
 
 // NewClient creates a new WebSocket client
 func NewClient(hub *Hub, conn *websocket.Conn, roomID, userID, userName string) *Client {
     return &Client{
         Hub:      hub,
+        id:       uuid.NewString(),
         conn:     conn,
         send:     make(chan []byte, 256),
         roomID:   roomID,
@@ -52,10 +100,35 @@ func NewClient(hub *Hub, conn *websocket.Conn, roomID, userID, userName string)
     }
 }
 
+// ResumeFrom asks for the events after seq to be replayed when the client
+// registers. It must be called before Register.
+func (c *Client) ResumeFrom(seq uint64) {
+    c.lastSeq = seq
+}
+
+// SetRole records the member role the client connected with. It must be
+// called before Register.
+func (c *Client) SetRole(role string) {
+    c.role = role
+}
+
+// InLobby makes the client wait in the room's lobby until an admin admits
+// or denies it. It must be called before Register.
+func (c *Client) InLobby() {
+    c.lobby.Store(true)
+}
+
+// AsGuest marks a client that connected with a guest token. It must be
+// called before Register.
+func (c *Client) AsGuest(allowChat bool) {
+    c.guest = true
+    c.guestChat = allowChat
+}
+
 // ReadPump pumps messages from the WebSocket connection to the hub
 func (c *Client) ReadPump() {
     defer func() {
-        c.Hub.unregister <- c
+        c.Hub.unregister(c)
         c.conn.Close()
     }()
 
@@ -66,19 +139,6 @@ func (c *Client) ReadPump() {
         return nil
     })
 
-    // Send user joined message
-    joinMsg := Message{
-        Type:      TypeUserJoined,
-        RoomID:    c.roomID,
-        UserID:    c.userID,
-        Timestamp: time.Now(),
-        Metadata: Metadata{
-            UserName: c.userName,
-        },
-    }
-    jsonMsg, _ := json.Marshal(joinMsg)
-    c.Hub.broadcast <- jsonMsg
-
     for {
         _, message, err := c.conn.ReadMessage()
         if err != nil {
@@ -0,0 +1,147 @@
+package websockets
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// historySize bounds how many sequenced events are kept for replay. It
+	// stays below the client send buffer so a full replay never blocks.
+	historySize = 128
+
+	// historyTTL expires the Redis backlog of rooms nobody is talking in
+	historyTTL = time.Hour
+
+	seqKeyPrefix     = "ws:seq:"
+	historyKeyPrefix = "ws:history:"
+)
+
+// leaveGracePeriod holds back user_left so a quick reconnect is invisible.
+// A variable so tests can shorten it.
+var leaveGracePeriod = 10 * time.Second
+
+// sequenced reports whether the envelope gets a room sequence number. Only
+// room-wide, non-ephemeral events are numbered and kept for replay.
+func (e envelope) sequenced() bool {
+	return !e.Ephemeral && e.TargetUserID == "" && e.TargetClientID == ""
+}
+
+// withSeq stamps the sequence number into a marshaled Message. Messages are
+// always marshaled with Seq unset, so the field is never duplicated.
+func withSeq(data []byte, seq uint64) []byte {
+	if seq == 0 || len(data) < 2 || data[0] != '{' {
+		return data
+	}
+
+	prefix := `{"seq":` + strconv.FormatUint(seq, 10)
+	if len(data) > 2 {
+		prefix += ","
+	}
+
+	stamped := make([]byte, 0, len(prefix)+len(data)-1)
+	stamped = append(stamped, prefix...)
+	return append(stamped, data[1:]...)
+}
+
+// backlog is the in-memory replay buffer used by a room actor when the hub
+// runs without a broker
+type backlog struct {
+	seq     uint64
+	entries []envelope
+}
+
+func (b *backlog) append(env envelope) envelope {
+	b.seq++
+	env.Seq = b.seq
+
+	b.entries = append(b.entries, env)
+	if len(b.entries) > historySize {
+		b.entries = b.entries[len(b.entries)-historySize:]
+	}
+	return env
+}
+
+// since returns the events after seq, and false when some of them have
+// already been dropped from the buffer
+func (b *backlog) since(seq uint64) ([]envelope, bool) {
+	if seq > b.seq {
+		// The room restarted since the client last saw it
+		return nil, false
+	}
+
+	missed := []envelope{}
+	for _, env := range b.entries {
+		if env.Seq > seq {
+			missed = append(missed, env)
+		}
+	}
+
+	complete := len(missed) == int(b.seq-seq)
+	return missed, complete
+}
+
+// sequencedPublish numbers an envelope, appends it to the room backlog and
+// publishes it in one step, so every instance sees events in sequence order
+var sequencedPublish = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+local env = '{"seq":' .. seq .. ',' .. string.sub(ARGV[1], 2)
+redis.call('RPUSH', KEYS[2], env)
+redis.call('LTRIM', KEYS[2], -tonumber(ARGV[2]), -1)
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+redis.call('PUBLISH', KEYS[3], env)
+return seq
+`)
+
+func seqKey(roomID string) string {
+	return seqKeyPrefix + roomID
+}
+
+func historyKey(roomID string) string {
+	return historyKeyPrefix + roomID
+}
+
+// publishSequenced runs sequencedPublish for a marshaled envelope
+func (b *Broker) publishSequenced(roomID string, data []byte) error {
+	keys := []string{seqKey(roomID), historyKey(roomID), roomChannel(roomID)}
+	return sequencedPublish.Run(b.ctx, b.client, keys, data, historySize, int(historyTTL.Seconds())).Err()
+}
+
+// Since returns the room events after seq from the shared backlog, and false
+// when some of them have already been trimmed away
+func (b *Broker) Since(roomID string, seq uint64) ([]envelope, bool, error) {
+	current, err := b.client.Get(b.ctx, seqKey(roomID)).Uint64()
+	if err == redis.Nil {
+		return nil, seq == 0, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if seq > current {
+		return nil, false, nil
+	}
+
+	raw, err := b.client.LRange(b.ctx, historyKey(roomID), 0, -1).Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	missed := []envelope{}
+	for _, item := range raw {
+		var env envelope
+		if err := json.Unmarshal([]byte(item), &env); err != nil {
+			continue
+		}
+		// Anything newer than current is still on its way through pub/sub
+		if env.Seq > seq && env.Seq <= current {
+			missed = append(missed, env)
+		}
+	}
+
+	complete := len(missed) == int(current-seq)
+	return missed, complete, nil
+}
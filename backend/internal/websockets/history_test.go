@@ -0,0 +1,110 @@
+package websockets
+
+import (
+	"testing"
+)
+
+func TestWithSeq(t *testing.T) {
+	tests := []struct {
+		data string
+		seq  uint64
+		want string
+	}{
+		{`{"type":"message"}`, 7, `{"seq":7,"type":"message"}`},
+		{`{}`, 3, `{"seq":3}`},
+		{`{"type":"message"}`, 0, `{"type":"message"}`},
+		{`[1,2]`, 5, `[1,2]`},
+		{``, 5, ``},
+	}
+
+	for _, tt := range tests {
+		if got := string(withSeq([]byte(tt.data), tt.seq)); got != tt.want {
+			t.Errorf("withSeq(%q, %d) = %q, want %q", tt.data, tt.seq, got, tt.want)
+		}
+	}
+}
+
+func TestWithSeqLeavesInputAlone(t *testing.T) {
+	data := []byte(`{"type":"message"}`)
+	withSeq(data, 1)
+	if string(data) != `{"type":"message"}` {
+		t.Errorf("withSeq modified its input: %q", data)
+	}
+}
+
+func TestBacklogSince(t *testing.T) {
+	var b backlog
+	for i := 0; i < 3; i++ {
+		env := b.append(envelope{RoomID: "room"})
+		if env.Seq != uint64(i+1) {
+			t.Fatalf("append numbered envelope %d as %d", i+1, env.Seq)
+		}
+	}
+
+	missed, complete := b.since(1)
+	if !complete || len(missed) != 2 || missed[0].Seq != 2 || missed[1].Seq != 3 {
+		t.Errorf("since(1) = %v, %v, want seq 2 and 3, complete", missed, complete)
+	}
+
+	missed, complete = b.since(3)
+	if !complete || len(missed) != 0 {
+		t.Errorf("since(3) = %v, %v, want nothing, complete", missed, complete)
+	}
+
+	missed, complete = b.since(0)
+	if !complete || len(missed) != 3 {
+		t.Errorf("since(0) = %v, %v, want all 3, complete", missed, complete)
+	}
+}
+
+func TestBacklogSinceTrimmed(t *testing.T) {
+	var b backlog
+	for i := 0; i < historySize+10; i++ {
+		b.append(envelope{RoomID: "room"})
+	}
+
+	if len(b.entries) != historySize {
+		t.Fatalf("backlog kept %d entries, want %d", len(b.entries), historySize)
+	}
+
+	missed, complete := b.since(0)
+	if complete {
+		t.Error("since(0) reported complete after events were trimmed")
+	}
+	if len(missed) != historySize || missed[0].Seq != 11 {
+		t.Errorf("since(0) returned %d events from seq %d, want %d from seq 11", len(missed), missed[0].Seq, historySize)
+	}
+
+	missed, complete = b.since(10)
+	if !complete || len(missed) != historySize {
+		t.Errorf("since(10) = %d events, %v, want %d, complete", len(missed), complete, historySize)
+	}
+}
+
+func TestBacklogSinceRestartedRoom(t *testing.T) {
+	var b backlog
+	b.append(envelope{RoomID: "room"})
+
+	missed, complete := b.since(5)
+	if complete || missed != nil {
+		t.Errorf("since(5) = %v, %v, want nothing, incomplete", missed, complete)
+	}
+}
+
+func TestEnvelopeSequenced(t *testing.T) {
+	tests := []struct {
+		env  envelope
+		want bool
+	}{
+		{envelope{RoomID: "room"}, true},
+		{envelope{RoomID: "room", Ephemeral: true}, false},
+		{envelope{RoomID: "room", TargetUserID: "user"}, false},
+		{envelope{RoomID: "room", TargetClientID: "client"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.env.sequenced(); got != tt.want {
+			t.Errorf("%+v sequenced() = %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
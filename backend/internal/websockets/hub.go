@@ -5,123 +5,339 @@ import (
 	"log"
 	"sync"
 	"time"
+	"video-chat/internal/models"
+	"video-chat/internal/sfu"
+
+	"github.com/redis/go-redis/v9"
 )
 
-// Hub maintains the set of active clients and broadcasts messages to them
+// Hub keeps track of the live rooms on this instance. Each room runs as its
+// own actor (see roomHub), the hub only creates them on demand and shuts them
+// down once the last client has gone.
 type Hub struct {
-	// Registered clients
-	clients map[*Client]bool
+	// Live rooms keyed by room ID
+	rooms map[string]*roomHub
 
-	// Inbound messages from the clients
-	broadcast chan []byte
+	// Mutex for rooms map and the per-room reference counts
+	roomsMutex sync.Mutex
 
-	// Register requests from the clients
-	Register chan *Client
+	// Every live connection of a user, across rooms and devices
+	userSessions      map[string]map[*Client]bool
+	userSessionsMutex sync.RWMutex
 
-	// Unregister requests from clients
-	unregister chan *Client
+	// Fan-out to other instances, nil when running as a single instance
+	broker *Broker
 
-	// Room-specific clients
-	rooms map[string]map[*Client]bool
+	// Persistence for chat messages
+	store Store
 
-	// Mutex for rooms map
-	roomsMutex sync.RWMutex
+	// Called once the last participant has left a room
+	meetingEndedHooks []func(roomID string)
 
-	// User sessions
-	userSessions      map[string]*Client
-	userSessionsMutex sync.RWMutex
+	// Called before a user is removed from a room
+	kickedHooks []func(roomID, userID string)
+
+	// Meeting session changes on their way to the store
+	sessionEvents chan sessionEvent
+
+	// Media relay, nil when clients connect to each other directly. With a
+	// broker it is only used while this instance holds the relay's lease,
+	// relaying tells whether it does.
+	media      *sfu.SFU
+	relaying   bool
+	mediaMutex sync.RWMutex
 }
 
-// NewHub creates a new Hub instance
-func NewHub() *Hub {
-	return &Hub{
-		broadcast:    make(chan []byte),
-		Register:     make(chan *Client),
-		unregister:   make(chan *Client),
-		clients:      make(map[*Client]bool),
-		rooms:        make(map[string]map[*Client]bool),
-		userSessions: make(map[string]*Client),
+// NewHub creates a new Hub instance. When a Redis client is given, room and
+// peer messages are relayed through Redis so every replica sees them.
+func NewHub(redisClient *redis.Client, store Store) *Hub {
+	var broker *Broker
+	if redisClient != nil {
+		broker = NewBroker(redisClient)
 	}
+
+	hub := &Hub{
+		broker:        broker,
+		store:         store,
+		rooms:         make(map[string]*roomHub),
+		userSessions:  make(map[string]map[*Client]bool),
+		sessionEvents: make(chan sessionEvent, sessionBufferSize),
+	}
+	go hub.recordSessions()
+	go hub.sweepSessions()
+
+	return hub
 }
 
+// Run consumes traffic published by other instances and keeps this
+// instance's presence alive. Rooms run on their own goroutines, so without a
+// broker there is nothing to do here.
 func (h *Hub) Run() {
-	for {
-		select {
-		case client := <-h.Register:
-			h.clients[client] = true
-			h.roomsMutex.Lock()
-			if _, ok := h.rooms[client.roomID]; !ok {
-				h.rooms[client.roomID] = make(map[*Client]bool)
-			}
-			h.rooms[client.roomID][client] = true
-			h.roomsMutex.Unlock()
-
-			// Add to user sessions
-			h.userSessionsMutex.Lock()
-			h.userSessions[client.userID] = client
-			h.userSessionsMutex.Unlock()
-
-		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-
-				h.roomsMutex.Lock()
-				if room, ok := h.rooms[client.roomID]; ok {
-					delete(room, client)
-					if len(room) == 0 {
-						delete(h.rooms, client.roomID)
-					}
-				}
-				h.roomsMutex.Unlock()
-
-				// Remove from user sessions
-				h.userSessionsMutex.Lock()
-				delete(h.userSessions, client.userID)
-				h.userSessionsMutex.Unlock()
-
-				// Send user left message
-				leaveMsg := Message{
-					Type:      TypeUserLeft,
-					RoomID:    client.roomID,
-					UserID:    client.userID,
-					Timestamp: time.Now(),
-					Metadata: Metadata{
-						UserName: client.userName,
-					},
+	if h.broker != nil {
+		go h.keepPresence()
+		h.broker.Receive(h.deliver)
+	}
+}
+
+// keepPresence refreshes this instance's presence in every room it runs, so
+// it only expires once the instance is gone
+func (h *Hub) keepPresence() {
+	ticker := time.NewTicker(presenceTTL / 3)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.roomsMutex.Lock()
+		rooms := make([]*roomHub, 0, len(h.rooms))
+		for _, room := range h.rooms {
+			rooms = append(rooms, room)
+		}
+		h.roomsMutex.Unlock()
+
+		for _, room := range rooms {
+			room.do(func() {
+				if err := h.broker.RefreshPresence(room.id, room.users); err != nil {
+					log.Printf("error refreshing presence in room %s: %v", room.id, err)
 				}
-				jsonMsg, _ := json.Marshal(leaveMsg)
-				h.broadcastToRoom(client.roomID, jsonMsg)
+			})
+		}
+	}
+}
+
+// OnMeetingEnded registers fn to run, on its own goroutine, once the last
+// participant has left a room. Hooks must be registered before clients connect.
+func (h *Hub) OnMeetingEnded(fn func(roomID string)) {
+	h.meetingEndedHooks = append(h.meetingEndedHooks, fn)
+}
+
+// OnKicked registers fn to run before a user is removed from a room, so
+// whatever let them in can be taken away first. Hooks must be registered
+// before clients connect.
+func (h *Hub) OnKicked(fn func(roomID, userID string)) {
+	h.kickedHooks = append(h.kickedHooks, fn)
+}
+
+// UseSFU relays the participants' media through media instead of leaving
+// them to connect to each other. It must be called before clients connect.
+// With a broker only one instance relays at a time, clients of the others
+// keep connecting to each other directly until it takes over the relay.
+func (h *Hub) UseSFU(media *sfu.SFU) {
+	h.media = media
+	if h.broker == nil {
+		h.relaying = true
+		return
+	}
+	go h.keepSFULease()
+}
+
+func (h *Hub) meetingEnded(roomID string) {
+	for _, fn := range h.meetingEndedHooks {
+		go fn(roomID)
+	}
+}
+
+// Register attaches a client to its room, starting the room actor if needed
+func (h *Hub) Register(client *Client) {
+	room := h.acquireRoom(client.roomID)
+	client.room = room
+	room.register <- client
+
+	// Add to user sessions
+	h.userSessionsMutex.Lock()
+	if _, ok := h.userSessions[client.userID]; !ok {
+		h.userSessions[client.userID] = make(map[*Client]bool)
+		if h.broker != nil {
+			if err := h.broker.SubscribeUser(client.userID); err != nil {
+				log.Printf("error subscribing to user %s: %v", client.userID, err)
 			}
+		}
+	}
+	h.userSessions[client.userID][client] = true
+	h.userSessionsMutex.Unlock()
+}
+
+// unregister detaches a client from its room. Called once per client when
+// its read pump exits. The room drops the client's reference itself, after
+// holding a seat for a reconnect.
+func (h *Hub) unregister(client *Client) {
+	client.room.unregister <- client
+
+	if h.media != nil {
+		h.media.Leave(client.roomID, client.id)
+	}
 
-		case message := <-h.broadcast:
-			h.roomsMutex.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+	// Remove from user sessions, keeping the user's other connections
+	h.userSessionsMutex.Lock()
+	if sessions, ok := h.userSessions[client.userID]; ok {
+		delete(sessions, client)
+		if len(sessions) == 0 {
+			delete(h.userSessions, client.userID)
+			if h.broker != nil {
+				if err := h.broker.UnsubscribeUser(client.userID); err != nil {
+					log.Printf("error unsubscribing from user %s: %v", client.userID, err)
 				}
 			}
-			h.roomsMutex.RUnlock()
 		}
 	}
+	h.userSessionsMutex.Unlock()
 }
 
-func (h *Hub) broadcastToRoom(roomID string, message []byte) {
-	h.roomsMutex.RLock()
-	if room, ok := h.rooms[roomID]; ok {
-		for client := range room {
-			select {
-			case client.send <- message:
-			default:
-				close(client.send)
-				delete(room, client)
+// acquireRoom returns the room actor, taking a reference that keeps it alive
+func (h *Hub) acquireRoom(roomID string) *roomHub {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+
+	room, ok := h.rooms[roomID]
+	if !ok {
+		room = newRoomHub(h, roomID)
+		h.rooms[roomID] = room
+		go room.run()
+
+		// Subscribed and unsubscribed under the lock, so a room shutting
+		// down can't unsubscribe its successor
+		if h.broker != nil {
+			if err := h.broker.SubscribeRoom(roomID); err != nil {
+				log.Printf("error subscribing to room %s: %v", roomID, err)
 			}
 		}
 	}
-	h.roomsMutex.RUnlock()
+	room.refs++
+	return room
+}
+
+// releaseRoom drops a reference and stops the actor when nobody holds it
+func (h *Hub) releaseRoom(room *roomHub) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+
+	room.refs--
+	if room.refs == 0 {
+		delete(h.rooms, room.id)
+		close(room.stop)
+
+		if h.broker != nil {
+			if err := h.broker.UnsubscribeRoom(room.id); err != nil {
+				log.Printf("error unsubscribing from room %s: %v", room.id, err)
+			}
+		}
+	}
+}
+
+// retainRoom takes an extra reference on a live room, used to keep it
+// around while a departure is in its grace period
+func (h *Hub) retainRoom(room *roomHub) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+
+	room.refs++
+}
+
+// getRoom returns the live room actor, or nil when nobody is connected here
+func (h *Hub) getRoom(roomID string) *roomHub {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+
+	return h.rooms[roomID]
+}
+
+// UserConnectionCount returns how many connections the user holds on this instance
+func (h *Hub) UserConnectionCount(userID string) int {
+	h.userSessionsMutex.RLock()
+	defer h.userSessionsMutex.RUnlock()
+
+	return len(h.userSessions[userID])
+}
+
+// RoomUserCount returns the number of distinct users connected to a room
+func (h *Hub) RoomUserCount(roomID string) int {
+	if h.broker != nil {
+		count, err := h.broker.PresentUsers(roomID)
+		if err == nil {
+			return int(count)
+		}
+		log.Printf("error reading presence of room %s: %v", roomID, err)
+	}
+
+	room := h.getRoom(roomID)
+	if room == nil {
+		return 0
+	}
+
+	count := 0
+	room.do(func() {
+		count = len(room.users)
+	})
+	return count
+}
+
+// IsUserInRoom reports whether the user already has a connection to the room
+func (h *Hub) IsUserInRoom(roomID, userID string) bool {
+	if h.broker != nil {
+		present, err := h.broker.IsPresent(roomID, userID)
+		if err == nil {
+			return present
+		}
+		log.Printf("error reading presence of room %s: %v", roomID, err)
+	}
+
+	room := h.getRoom(roomID)
+	if room == nil {
+		return false
+	}
+
+	found := false
+	room.do(func() {
+		found = room.users[userID] > 0
+	})
+	return found
+}
+
+// publish hands an envelope to the broker, or delivers it directly when the
+// hub is running without one
+func (h *Hub) publish(env envelope) {
+	if h.broker == nil {
+		h.deliver(env)
+		return
+	}
+
+	if err := h.broker.Publish(env); err != nil {
+		log.Printf("error publishing to room %s: %v", env.RoomID, err)
+	}
+}
+
+// deliver queues an envelope on the room actor, if the room is live here.
+// Envelopes without a room go to every local connection of the target user.
+func (h *Hub) deliver(env envelope) {
+	if env.RoomID == "" {
+		h.deliverToUser(env)
+		return
+	}
+
+	if room := h.getRoom(env.RoomID); room != nil {
+		room.post(env)
+	}
+}
+
+func (h *Hub) deliverToUser(env envelope) {
+	h.userSessionsMutex.RLock()
+	clients := make([]*Client, 0, len(h.userSessions[env.TargetUserID]))
+	for client := range h.userSessions[env.TargetUserID] {
+		clients = append(clients, client)
+	}
+	h.userSessionsMutex.RUnlock()
+
+	for _, client := range clients {
+		direct := env
+		direct.RoomID = client.roomID
+		direct.TargetClientID = client.id
+		client.room.post(direct)
+	}
+}
+
+// SendToUser notifies a user on all of their connections, whichever rooms
+// they are in
+func (h *Hub) SendToUser(userID string, msg Message) {
+	msg.Timestamp = time.Now()
+	h.publishMessage(&msg, envelope{TargetUserID: userID})
 }
 
 func (h *Hub) handleMessage(message []byte, sender *Client) {
@@ -131,39 +347,155 @@ func (h *Hub) handleMessage(message []byte, sender *Client) {
 		return
 	}
 
-	// Set message metadata
+	// Until admitted, lobby clients have nothing to say to the room
+	if sender.lobby.Load() {
+		h.sendError(sender, "waiting to be admitted to the room")
+		return
+	}
+
+	// Set message metadata. Clients can only ever talk to the room they
+	// are connected to.
 	msg.Timestamp = time.Now()
 	msg.UserID = sender.userID
+	msg.RoomID = sender.roomID
+	msg.Metadata.ClientID = sender.id
 
 	// Handle different message types
 	switch msg.Type {
 	case TypeMessage:
-		h.roomsMutex.RLock()
-		if room, ok := h.rooms[msg.RoomID]; ok {
-			for client := range room {
-				// Skip sending to the sender
-				if client != sender {
-					select {
-					case client.send <- message:
-					default:
-						close(client.send)
-						delete(room, client)
-					}
-				}
-			}
+		if sender.guest && !sender.guestChat {
+			h.sendError(sender, "chat is not enabled for guests")
+			return
 		}
-		h.roomsMutex.RUnlock()
+
+		create := h.store.CreateMessage
+		if sender.guest {
+			create = h.store.CreateGuestMessage
+		}
+		message, err := create(msg.RoomID, sender.userID, msg.Content)
+		if err != nil {
+			h.sendError(sender, err.Error())
+			return
+		}
+		h.PublishChatEvent(TypeMessage, message)
+
+	case TypeMessageEdited:
+		message, err := h.store.UpdateMessage(msg.RoomID, msg.Metadata.MessageID, sender.userID, msg.Content)
+		if err != nil {
+			h.sendError(sender, err.Error())
+			return
+		}
+		h.PublishChatEvent(TypeMessageEdited, message)
+
+	case TypeMessageDeleted:
+		message, err := h.store.RemoveMessage(msg.RoomID, msg.Metadata.MessageID, sender.userID)
+		if err != nil {
+			h.sendError(sender, err.Error())
+			return
+		}
+		h.PublishChatEvent(TypeMessageDeleted, message)
 
 	case TypeTyping:
-		h.broadcastToRoom(msg.RoomID, message)
+		h.publishMessage(&msg, envelope{RoomID: msg.RoomID, Ephemeral: true})
 
 	case TypeReadReceipt:
-		h.broadcastToRoom(msg.RoomID, message)
+		h.publishMessage(&msg, envelope{RoomID: msg.RoomID})
+
+	case TypeKick, TypeMute, TypeLock, TypeEndMeeting:
+		h.handleModeration(&msg, sender)
+
+	case TypeAdmit, TypeDeny:
+		h.handleLobbyDecision(&msg, sender)
+
+	case TypeScreenShare:
+		h.handleScreenShare(&msg, sender)
+
+	case TypeStatsReport:
+		h.handleStatsReport(&msg, sender)
+
+	case TypeAudioLevel:
+		h.handleAudioLevel(&msg, sender)
+
+	case TypeOffer, TypeAnswer, TypeICECandidate, TypeRenegotiate:
+		h.sendToPeer(&msg, sender)
+
+	case TypeSFUJoin, TypeSFUAnswer, TypeSFUCandidate, TypeSFULeave:
+		h.handleMedia(&msg, sender)
+	}
+}
+
+// PublishChatEvent broadcasts a stored chat message to everyone in its room,
+// including the sender so it learns the server-assigned ID
+func (h *Hub) PublishChatEvent(msgType MessageType, message *models.Message) {
+	msg := Message{
+		Type:      msgType,
+		RoomID:    message.RoomID,
+		UserID:    message.UserID,
+		Content:   message.Content,
+		Timestamp: message.CreatedAt,
+		Metadata: Metadata{
+			MessageID: message.ID,
+		},
+	}
+	if msgType != TypeMessage {
+		msg.Timestamp = time.Now()
+	}
+	if msgType == TypeMessageDeleted {
+		msg.Content = ""
+	}
 
-	case TypeUserJoined:
-		h.broadcastToRoom(msg.RoomID, message)
+	h.publishMessage(&msg, envelope{RoomID: message.RoomID})
+}
+
+// publishMessage marshals msg into the envelope and publishes it
+func (h *Hub) publishMessage(msg *Message, env envelope) {
+	jsonMsg, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("error marshaling message: %v", err)
+		return
+	}
+
+	env.Data = jsonMsg
+	h.publish(env)
+}
+
+// sendToPeer delivers a signaling message to the target user in the sender's room
+func (h *Hub) sendToPeer(msg *Message, sender *Client) {
+	if msg.TargetID == "" || msg.TargetID == sender.userID {
+		h.sendError(sender, "signaling message requires a valid targetId")
+		return
+	}
+
+	// Without a broker the target can only be on this instance, so a missing
+	// peer is reported back right away
+	if h.broker == nil && !h.IsUserInRoom(sender.roomID, msg.TargetID) {
+		h.sendError(sender, "target peer is not connected to this room")
+		return
+	}
+
+	// TargetClientID lets a peer answer the exact connection that sent an
+	// offer when the user has several open
+	h.publishMessage(msg, envelope{
+		RoomID:         sender.roomID,
+		TargetUserID:   msg.TargetID,
+		TargetClientID: msg.TargetClientID,
+	})
+}
+
+// sendError reports a problem with a message back to its sender only
+func (h *Hub) sendError(client *Client, reason string) {
+	// Goes through the actor, which is the only writer to client.send
+	client.room.post(envelope{RoomID: client.roomID, TargetClientID: client.id, Data: errorMessage(client, reason)})
+}
 
-	case TypeUserLeft:
-		h.broadcastToRoom(msg.RoomID, message)
+func errorMessage(client *Client, reason string) []byte {
+	errMsg := Message{
+		Type:      TypeError,
+		RoomID:    client.roomID,
+		UserID:    client.userID,
+		Content:   reason,
+		Timestamp: time.Now(),
 	}
+	jsonMsg, _ := json.Marshal(errMsg)
+	return jsonMsg
 }
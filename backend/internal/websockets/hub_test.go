@@ -0,0 +1,605 @@
+package websockets
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"video-chat/internal/models"
+	"video-chat/internal/sfu"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// fakeStore satisfies Store without a database. Messages are accepted as
+// they are and every session event is ignored.
+type fakeStore struct{}
+
+func (fakeStore) GetRoom(roomId string) (*models.Room, error) {
+	return &models.Room{ID: roomId}, nil
+}
+
+func (fakeStore) CreateMessage(roomId, userId, content string) (*models.Message, error) {
+	return &models.Message{RoomID: roomId, UserID: userId, Content: content, CreatedAt: time.Now()}, nil
+}
+
+func (s fakeStore) CreateGuestMessage(roomId, guestId, content string) (*models.Message, error) {
+	return s.CreateMessage(roomId, guestId, content)
+}
+
+func (fakeStore) UpdateMessage(roomId, messageId, userId, content string) (*models.Message, error) {
+	return nil, nil
+}
+
+func (fakeStore) RemoveMessage(roomId, messageId, userId string) (*models.Message, error) {
+	return nil, nil
+}
+
+func (fakeStore) HasRoomPermission(roomId, userId, perm string) (bool, error) { return false, nil }
+func (fakeStore) RolesWithPermission(perm string) []string                    { return nil }
+
+func (fakeStore) OutranksMember(roomId, actorId, targetId string) (bool, error) { return false, nil }
+
+func (fakeStore) RolesOutranked(roomId, actorId string) ([]string, error) { return nil, nil }
+
+func (fakeStore) ReviewLobbyRequest(roomId, userId, reviewerId, reason string, admit bool) error {
+	return nil
+}
+
+func (fakeStore) BanFromRoom(roomId, userId, bannedBy, reason string) error { return nil }
+
+func (fakeStore) StartMeetingSession(roomId, sessionId string, startedAt time.Time) error {
+	return nil
+}
+
+func (fakeStore) EndMeetingSession(roomId, sessionId string, endedAt time.Time) error { return nil }
+
+func (fakeStore) JoinMeetingSession(roomId, sessionId, userId, displayName string, isGuest bool, joinedAt time.Time) error {
+	return nil
+}
+
+func (fakeStore) RejoinMeetingSession(roomId, sessionId, userId string) error { return nil }
+
+func (fakeStore) LeaveMeetingSession(roomId, sessionId, userId string, leftAt time.Time) error {
+	return nil
+}
+
+func (fakeStore) OpenMeetingSessions(startedBefore time.Time) ([]models.MeetingSession, error) {
+	return nil, nil
+}
+
+func (fakeStore) OpenSessionParticipants(sessionId string, joinedBefore time.Time) ([]string, error) {
+	return nil, nil
+}
+
+func (fakeStore) RecordMeetingTelemetry(roomId, sessionId, userId string, totals TelemetryTotals) error {
+	return nil
+}
+
+// rankedStore gives the users fixed ranks, anyone ranked can moderate
+type rankedStore struct {
+	fakeStore
+	ranks map[string]int
+}
+
+func (s rankedStore) HasRoomPermission(roomId, userId, perm string) (bool, error) {
+	return s.ranks[userId] > 0, nil
+}
+
+func (s rankedStore) OutranksMember(roomId, actorId, targetId string) (bool, error) {
+	return s.ranks[actorId] > s.ranks[targetId], nil
+}
+
+// RolesOutranked names each rank's role after its number
+func (s rankedStore) RolesOutranked(roomId, actorId string) ([]string, error) {
+	roles := []string{}
+	for rank := 0; rank < s.ranks[actorId]; rank++ {
+		roles = append(roles, fmt.Sprint(rank))
+	}
+	return roles, nil
+}
+
+// sweptStore has the given meetings and participants open and reports what
+// the hub closes
+type sweptStore struct {
+	fakeStore
+	open         []models.MeetingSession
+	participants map[string][]string
+	closed       chan string
+}
+
+func (s sweptStore) OpenMeetingSessions(startedBefore time.Time) ([]models.MeetingSession, error) {
+	return s.open, nil
+}
+
+func (s sweptStore) OpenSessionParticipants(sessionId string, joinedBefore time.Time) ([]string, error) {
+	return s.participants[sessionId], nil
+}
+
+func (s sweptStore) EndMeetingSession(roomId, sessionId string, endedAt time.Time) error {
+	s.closed <- "session " + sessionId
+	return nil
+}
+
+func (s sweptStore) LeaveMeetingSession(roomId, sessionId, userId string, leftAt time.Time) error {
+	s.closed <- "user " + userId
+	return nil
+}
+
+// smallRoomStore has rooms with a single seat
+type smallRoomStore struct {
+	fakeStore
+}
+
+func (smallRoomStore) GetRoom(roomId string) (*models.Room, error) {
+	return &models.Room{ID: roomId, MaxUsers: 1, MuteOnEntry: true}, nil
+}
+
+var testClients atomic.Int64
+
+// newTestClient makes a client without a websocket connection, the test
+// reads what the room sends it straight from its send channel
+func newTestClient(userID string, buffer int) *Client {
+	return &Client{
+		id:     fmt.Sprintf("%s-%d", userID, testClients.Add(1)),
+		send:   make(chan []byte, buffer),
+		roomID: "room",
+		userID: userID,
+	}
+}
+
+// receive reads messages off the client until one of type msgType arrives
+func receive(t *testing.T, client *Client, msgType MessageType) Message {
+	t.Helper()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case data, ok := <-client.send:
+			if !ok {
+				t.Fatalf("%s was disconnected waiting for %s", client.userID, msgType)
+			}
+			var msg Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatalf("%s received invalid JSON: %v", client.userID, err)
+			}
+			if msg.Type == msgType {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("%s never received %s", client.userID, msgType)
+		}
+	}
+}
+
+// quiet fails if the client receives a message of any of the types within wait
+func quiet(t *testing.T, client *Client, wait time.Duration, types ...MessageType) {
+	t.Helper()
+
+	timeout := time.After(wait)
+	for {
+		select {
+		case data, ok := <-client.send:
+			if !ok {
+				return
+			}
+			var msg Message
+			json.Unmarshal(data, &msg)
+			if slices.Contains(types, msg.Type) {
+				t.Fatalf("%s unexpectedly received %s from %s", client.userID, msg.Type, msg.UserID)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func roomCount(h *Hub) int {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	return len(h.rooms)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func shortGracePeriod(t *testing.T) {
+	previous := leaveGracePeriod
+	leaveGracePeriod = 100 * time.Millisecond
+	t.Cleanup(func() { leaveGracePeriod = previous })
+}
+
+func TestHubBroadcastManyClients(t *testing.T) {
+	shortGracePeriod(t)
+
+	const clients = 50
+	const perClient = 2
+
+	hub := NewHub(nil, fakeStore{})
+	ended := make(chan string, 1)
+	hub.OnMeetingEnded(func(roomID string) { ended <- roomID })
+
+	all := make([]*Client, clients)
+	for i := range all {
+		all[i] = newTestClient(fmt.Sprintf("user-%d", i), 256)
+		hub.Register(all[i])
+	}
+
+	var wg sync.WaitGroup
+	for _, client := range all {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			for i := 0; i < perClient; i++ {
+				hub.handleMessage([]byte(`{"type":"read_receipt"}`), client)
+			}
+		}(client)
+	}
+	wg.Wait()
+
+	for _, client := range all {
+		seen := make(map[uint64]bool)
+		for len(seen) < clients*perClient {
+			msg := receive(t, client, TypeReadReceipt)
+			if msg.Seq == 0 || seen[msg.Seq] {
+				t.Fatalf("%s received read_receipt with seq %d twice or unnumbered", client.userID, msg.Seq)
+			}
+			seen[msg.Seq] = true
+		}
+	}
+
+	for _, client := range all {
+		hub.unregister(client)
+	}
+
+	select {
+	case <-ended:
+	case <-time.After(2 * time.Second):
+		t.Fatal("meeting never ended after everyone left")
+	}
+	waitFor(t, "the room to shut down", func() bool { return roomCount(hub) == 0 })
+}
+
+func TestHubSlowConsumerRemovedOnce(t *testing.T) {
+	shortGracePeriod(t)
+
+	hub := NewHub(nil, fakeStore{})
+	fast := newTestClient("fast", 256)
+	slow := newTestClient("slow", 1)
+	hub.Register(fast)
+	hub.Register(slow)
+
+	// The slow client never reads, so its one slot fills up and the room
+	// drops it. Closing its channel twice would panic the actor.
+	for i := 0; i < 10; i++ {
+		hub.handleMessage([]byte(`{"type":"read_receipt"}`), fast)
+	}
+	for i := 0; i < 10; i++ {
+		receive(t, fast, TypeReadReceipt)
+	}
+
+	room := fast.room
+	attached := true
+	room.do(func() { attached = room.clients[slow] })
+	if attached {
+		t.Fatal("slow consumer is still attached to the room")
+	}
+
+	// Its read pump still exits later on, which must not close it again
+	hub.unregister(slow)
+	hub.handleMessage([]byte(`{"type":"read_receipt"}`), fast)
+	receive(t, fast, TypeReadReceipt)
+
+	closed := false
+	for !closed {
+		select {
+		case _, ok := <-slow.send:
+			closed = !ok
+		case <-time.After(time.Second):
+			t.Fatal("slow consumer's send channel was never closed")
+		}
+	}
+
+	hub.unregister(fast)
+	waitFor(t, "the room to shut down", func() bool { return roomCount(hub) == 0 })
+}
+
+func TestHubGracePeriodHandOver(t *testing.T) {
+	shortGracePeriod(t)
+
+	hub := NewHub(nil, fakeStore{})
+	watcher := newTestClient("watcher", 256)
+	hub.Register(watcher)
+	receive(t, watcher, TypeUserJoined)
+
+	first := newTestClient("alice", 256)
+	hub.Register(first)
+	receive(t, watcher, TypeUserJoined)
+
+	// A reconnect within the grace period takes the held seat silently
+	hub.unregister(first)
+	second := newTestClient("alice", 256)
+	hub.Register(second)
+	quiet(t, watcher, 3*leaveGracePeriod, TypeUserLeft, TypeUserJoined)
+
+	if !hub.IsUserInRoom("room", "alice") {
+		t.Fatal("alice lost her seat during the hand-over")
+	}
+
+	// Staying away for longer is announced once the grace period is over
+	hub.unregister(second)
+	if !hub.IsUserInRoom("room", "alice") {
+		t.Fatal("alice's seat was not held during the grace period")
+	}
+	left := receive(t, watcher, TypeUserLeft)
+	if left.UserID != "alice" {
+		t.Fatalf("user_left announced %s, want alice", left.UserID)
+	}
+	if hub.IsUserInRoom("room", "alice") {
+		t.Fatal("alice is still in the room after the grace period")
+	}
+
+	// The last departure holds the room until its grace period is over
+	hub.unregister(watcher)
+	if roomCount(hub) != 1 {
+		t.Fatal("room shut down while a seat was still held")
+	}
+	waitFor(t, "the room to shut down", func() bool { return roomCount(hub) == 0 })
+}
+
+func TestHubPresenceAcrossConnections(t *testing.T) {
+	shortGracePeriod(t)
+
+	hub := NewHub(nil, fakeStore{})
+	watcher := newTestClient("watcher", 256)
+	hub.Register(watcher)
+	receive(t, watcher, TypeUserJoined)
+
+	phone := newTestClient("bob", 256)
+	laptop := newTestClient("bob", 256)
+	hub.Register(phone)
+	receive(t, watcher, TypeUserJoined)
+	hub.Register(laptop)
+	quiet(t, watcher, 50*time.Millisecond, TypeUserJoined)
+
+	if count := hub.RoomUserCount("room"); count != 2 {
+		t.Fatalf("RoomUserCount = %d, want 2", count)
+	}
+
+	// Closing one of two connections isn't a departure
+	hub.unregister(phone)
+	quiet(t, watcher, 3*leaveGracePeriod, TypeUserLeft)
+
+	hub.unregister(laptop)
+	receive(t, watcher, TypeUserLeft)
+	if count := hub.RoomUserCount("room"); count != 1 {
+		t.Fatalf("RoomUserCount = %d after bob left, want 1", count)
+	}
+
+	hub.unregister(watcher)
+	waitFor(t, "the room to shut down", func() bool { return roomCount(hub) == 0 })
+}
+
+func TestHubKickNeedsHigherRank(t *testing.T) {
+	shortGracePeriod(t)
+
+	hub := NewHub(nil, rankedStore{ranks: map[string]int{"admin": 2, "mod": 1, "other-mod": 1}})
+	admin := newTestClient("admin", 256)
+	mod := newTestClient("mod", 256)
+	otherMod := newTestClient("other-mod", 256)
+	member := newTestClient("member", 256)
+	for _, client := range []*Client{admin, mod, otherMod, member} {
+		hub.Register(client)
+	}
+
+	// Sharing a role isn't enough, nor is being outranked
+	for _, target := range []string{"other-mod", "admin"} {
+		hub.handleMessage([]byte(`{"type":"kick","targetId":"`+target+`"}`), mod)
+		receive(t, mod, TypeError)
+	}
+	quiet(t, otherMod, 50*time.Millisecond, TypeKicked)
+	quiet(t, admin, 0, TypeKicked)
+
+	hub.handleMessage([]byte(`{"type":"kick","targetId":"member"}`), mod)
+	receive(t, member, TypeKicked)
+
+	hub.handleMessage([]byte(`{"type":"kick","targetId":"mod"}`), admin)
+	receive(t, mod, TypeKicked)
+
+	for _, client := range []*Client{admin, mod, otherMod, member} {
+		hub.unregister(client)
+	}
+	waitFor(t, "the room to shut down", func() bool { return roomCount(hub) == 0 })
+}
+
+func TestHubSweepsAbandonedSessions(t *testing.T) {
+	shortGracePeriod(t)
+
+	store := sweptStore{closed: make(chan string, 10)}
+	hub := NewHub(nil, &store)
+	alice := newTestClient("alice", 256)
+	hub.Register(alice)
+	receive(t, alice, TypeUserJoined)
+
+	current := hub.currentMeeting("room")
+	if current == "" {
+		t.Fatal("room has no meeting after alice joined")
+	}
+
+	// An earlier meeting left open, a meeting in a room nobody is in, and
+	// bob, who left the current meeting without it being recorded
+	store.open = []models.MeetingSession{
+		{ID: "earlier", RoomID: "room"},
+		{ID: "empty", RoomID: "empty-room"},
+		{ID: current, RoomID: "room"},
+	}
+	store.participants = map[string][]string{current: {"alice", "bob"}}
+	hub.sweepOpenSessions(time.Now())
+
+	var closed []string
+	for len(closed) < 3 {
+		select {
+		case event := <-store.closed:
+			closed = append(closed, event)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("sweep closed %v, want 3 things", closed)
+		}
+	}
+	slices.Sort(closed)
+	if want := []string{"session earlier", "session empty", "user bob"}; !slices.Equal(closed, want) {
+		t.Fatalf("sweep closed %v, want %v", closed, want)
+	}
+
+	hub.unregister(alice)
+	waitFor(t, "the room to shut down", func() bool { return roomCount(hub) == 0 })
+}
+
+func TestHubActiveSpeaker(t *testing.T) {
+	shortGracePeriod(t)
+
+	hub := NewHub(nil, fakeStore{})
+	alice := newTestClient("alice", 256)
+	bob := newTestClient("bob", 256)
+	hub.Register(alice)
+	hub.Register(bob)
+
+	hub.handleMessage([]byte(`{"type":"audio_level","payload":{"level":0.5}}`), alice)
+	if speaker := receive(t, bob, TypeActiveSpeaker); speaker.UserID != "alice" {
+		t.Fatalf("active_speaker announced %s, want alice", speaker.UserID)
+	}
+
+	// Joining mid-meeting shows who has the floor
+	carol := newTestClient("carol", 256)
+	hub.Register(carol)
+	if speaker := receive(t, carol, TypeActiveSpeaker); speaker.UserID != "alice" {
+		t.Fatalf("carol was shown %s as the active speaker, want alice", speaker.UserID)
+	}
+
+	// Nobody has the floor once the speaker left
+	hub.unregister(alice)
+	receive(t, bob, TypeUserLeft)
+	dave := newTestClient("dave", 256)
+	hub.Register(dave)
+	quiet(t, dave, 50*time.Millisecond, TypeActiveSpeaker)
+
+	for _, client := range []*Client{bob, carol, dave} {
+		hub.unregister(client)
+	}
+	waitFor(t, "the room to shut down", func() bool { return roomCount(hub) == 0 })
+}
+
+func TestHubLobbyAdmissionChecksCapacity(t *testing.T) {
+	shortGracePeriod(t)
+
+	hub := NewHub(nil, smallRoomStore{})
+	knocker := newTestClient("knocker", 256)
+	knocker.InLobby()
+	hub.Register(knocker)
+
+	// The only seat is free, so the admitted user joins with the settings
+	hub.SettleLobby("room", "knocker", true, "")
+	receive(t, knocker, TypeAdmitted)
+	settings := receive(t, knocker, TypeRoomSettingsUpdated)
+	var payload struct {
+		MuteOnEntry bool `json:"mute_on_entry"`
+	}
+	json.Unmarshal(settings.Payload, &payload)
+	if !payload.MuteOnEntry {
+		t.Errorf("admitted user got settings %s, want mute_on_entry", settings.Payload)
+	}
+	if !hub.IsUserInRoom("room", "knocker") {
+		t.Fatal("admitted user didn't join the room")
+	}
+
+	// Now it is taken, the next one is turned away
+	late := newTestClient("late", 256)
+	late.InLobby()
+	hub.Register(late)
+	hub.SettleLobby("room", "late", true, "")
+	receive(t, late, TypeAdmitted)
+	if msg := receive(t, late, TypeError); msg.Content != "room is full" {
+		t.Errorf("late user got error %q, want room is full", msg.Content)
+	}
+	if hub.IsUserInRoom("room", "late") {
+		t.Fatal("admitted user joined a full room")
+	}
+
+	hub.unregister(late)
+	hub.unregister(knocker)
+	waitFor(t, "the room to shut down", func() bool { return roomCount(hub) == 0 })
+}
+
+func TestHubMuteNeedsHigherRank(t *testing.T) {
+	shortGracePeriod(t)
+
+	hub := NewHub(nil, rankedStore{ranks: map[string]int{"admin": 2, "mod": 1}})
+	admin := newTestClient("admin", 256)
+	mod := newTestClient("mod", 256)
+	member := newTestClient("member", 256)
+	admin.SetRole("2")
+	mod.SetRole("1")
+	member.SetRole("0")
+	for _, client := range []*Client{admin, mod, member} {
+		hub.Register(client)
+	}
+
+	hub.handleMessage([]byte(`{"type":"mute","targetId":"admin"}`), mod)
+	receive(t, mod, TypeError)
+	quiet(t, admin, 50*time.Millisecond, TypeMute)
+
+	// Muting everyone leaves out the staff above the sender
+	hub.handleMessage([]byte(`{"type":"mute"}`), mod)
+	receive(t, member, TypeMute)
+	quiet(t, admin, 50*time.Millisecond, TypeMute)
+
+	hub.handleMessage([]byte(`{"type":"mute","targetId":"mod"}`), admin)
+	receive(t, mod, TypeMute)
+
+	for _, client := range []*Client{admin, mod, member} {
+		hub.unregister(client)
+	}
+	waitFor(t, "the room to shut down", func() bool { return roomCount(hub) == 0 })
+}
+
+func TestHubStopsRelayingMedia(t *testing.T) {
+	shortGracePeriod(t)
+
+	relay, err := sfu.New(sfu.Options{SettingEngine: sfu.Loopback()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := NewHub(nil, fakeStore{})
+	hub.UseSFU(relay)
+
+	alice := newTestClient("alice", 256)
+	hub.Register(alice)
+	hub.handleMessage([]byte(`{"type":"sfu_join"}`), alice)
+	receive(t, alice, TypeSFUOffer)
+
+	// Losing the lease disconnects everyone and sends them back to the mesh
+	hub.stopRelaying()
+	if msg := receive(t, alice, TypeSFULeave); msg.Content == "" {
+		t.Error("sfu_leave doesn't tell the client why")
+	}
+	if err := relay.Answer("room", alice.id, webrtc.SessionDescription{}); err != sfu.ErrPeerNotFound {
+		t.Errorf("alice is still connected to the relay, answering got %v", err)
+	}
+
+	hub.handleMessage([]byte(`{"type":"sfu_join"}`), alice)
+	receive(t, alice, TypeError)
+
+	hub.unregister(alice)
+	waitFor(t, "the room to shut down", func() bool { return roomCount(hub) == 0 })
+}
@@ -0,0 +1,187 @@
+package websockets
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"time"
+	"video-chat/internal/models"
+)
+
+// Knock states carried in the Content of TypeKnock messages
+const (
+	KnockWaiting  = "waiting"
+	KnockAdmitted = "admitted"
+	KnockDenied   = "denied"
+	KnockLeft     = "left"
+)
+
+// Values of envelope.Lobby
+const (
+	lobbyAdmit = "admit"
+	lobbyDeny  = "deny"
+)
+
+// approverRoles are the member roles that see knocks and may answer them
+func (h *Hub) approverRoles() []string {
+	return h.store.RolesWithPermission(PermApproveJoins)
+}
+
+func knockMessage(roomID string, client *Client, state string) []byte {
+	msg := Message{
+		Type:      TypeKnock,
+		RoomID:    roomID,
+		UserID:    client.userID,
+		Content:   state,
+		Timestamp: time.Now(),
+		Metadata: Metadata{
+			UserName: client.userName,
+			ClientID: client.id,
+		},
+	}
+	jsonMsg, _ := json.Marshal(msg)
+	return jsonMsg
+}
+
+// knock tells the room's approvers, on every instance, about a waiting client
+func (r *roomHub) knock(client *Client, state string) {
+	r.emit(envelope{
+		RoomID:    r.id,
+		Roles:     r.hub.approverRoles(),
+		Ephemeral: true,
+		Data:      knockMessage(r.id, client, state),
+	})
+}
+
+// showLobby lets an approver who just connected know who is already waiting.
+// Only this instance's lobby is known here, the REST join request list has
+// the complete picture.
+func (r *roomHub) showLobby(client *Client) {
+	if len(r.waiting) == 0 || !slices.Contains(r.hub.approverRoles(), client.role) {
+		return
+	}
+
+	for waiting := range r.waiting {
+		r.send(client, 0, knockMessage(r.id, waiting, KnockWaiting))
+	}
+}
+
+// settleLobby hands the decision to the target user's waiting connections.
+// Admitted ones join the room as members, with the same capacity check and
+// entry settings as any other connection. Denied ones are closed, and so are
+// admitted ones when the room is full, they can connect again as members
+// once a seat frees up.
+func (r *roomHub) settleLobby(env envelope) {
+	var room *models.Room
+	if env.Lobby == lobbyAdmit {
+		var err error
+		if room, err = r.hub.store.GetRoom(r.id); err != nil {
+			log.Printf("error admitting to room %s: %v", r.id, err)
+		}
+	}
+
+	for client := range r.waiting {
+		if client.userID != env.TargetUserID {
+			continue
+		}
+
+		r.send(client, 0, env.Data)
+		if !r.waiting[client] {
+			// Dropped as a slow consumer
+			continue
+		}
+
+		if env.Lobby != lobbyAdmit || room == nil {
+			r.remove(client)
+			continue
+		}
+		if r.full(room, client.userID) {
+			r.send(client, 0, errorMessage(client, "room is full"))
+			r.remove(client)
+			continue
+		}
+
+		delete(r.waiting, client)
+		client.lobby.Store(false)
+		client.role = models.RoleMember
+		r.join(client)
+		settings, _ := json.Marshal(settingsMessage(room))
+		r.send(client, 0, settings)
+	}
+}
+
+// full reports whether the room has no seat left for the user. Users already
+// in the room keep their seat on another connection.
+func (r *roomHub) full(room *models.Room, userID string) bool {
+	if room.MaxUsers <= 0 || r.users[userID] > 0 {
+		return false
+	}
+	if r.hub.broker == nil {
+		return len(r.users) >= room.MaxUsers
+	}
+
+	present, err := r.hub.broker.IsPresent(r.id, userID)
+	if err == nil && present {
+		return false
+	}
+	count, err := r.hub.broker.PresentUsers(r.id)
+	if err != nil {
+		log.Printf("error reading presence of room %s: %v", r.id, err)
+		return len(r.users) >= room.MaxUsers
+	}
+	return int(count) >= room.MaxUsers
+}
+
+// SettleLobby tells a waiting user the outcome of their join request and
+// lets the other approvers know it has been handled
+func (h *Hub) SettleLobby(roomID, userID string, admit bool, reason string) {
+	outcome := Message{
+		Type:      TypeDenied,
+		RoomID:    roomID,
+		UserID:    userID,
+		Content:   reason,
+		Timestamp: time.Now(),
+	}
+	lobby, state := lobbyDeny, KnockDenied
+	if admit {
+		outcome.Type = TypeAdmitted
+		lobby, state = lobbyAdmit, KnockAdmitted
+	}
+	h.publishMessage(&outcome, envelope{RoomID: roomID, TargetUserID: userID, Lobby: lobby})
+
+	knock := Message{
+		Type:      TypeKnock,
+		RoomID:    roomID,
+		UserID:    userID,
+		Content:   state,
+		Timestamp: time.Now(),
+	}
+	h.publishMessage(&knock, envelope{RoomID: roomID, Roles: h.approverRoles(), Ephemeral: true})
+}
+
+// handleLobbyDecision lets an approver admit or deny a waiting user. The
+// join request is settled first, so two approvers can't both decide.
+func (h *Hub) handleLobbyDecision(msg *Message, sender *Client) {
+	if msg.TargetID == "" {
+		h.sendError(sender, "admit and deny require a targetId")
+		return
+	}
+
+	allowed, err := h.store.HasRoomPermission(sender.roomID, sender.userID, PermApproveJoins)
+	if err != nil {
+		h.sendError(sender, err.Error())
+		return
+	}
+	if !allowed {
+		h.sendError(sender, "you are not allowed to admit participants")
+		return
+	}
+
+	admit := msg.Type == TypeAdmit
+	if err := h.store.ReviewLobbyRequest(sender.roomID, msg.TargetID, sender.userID, msg.Content, admit); err != nil {
+		h.sendError(sender, err.Error())
+		return
+	}
+
+	h.SettleLobby(sender.roomID, msg.TargetID, admit, msg.Content)
+}
@@ -0,0 +1,157 @@
+package websockets
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	sfuLeaseKey = "ws:sfu:instance"
+
+	// sfuLeaseTTL is how long the relay's lease outlives an instance that
+	// stopped renewing it, another one only takes over after that
+	sfuLeaseTTL = 15 * time.Second
+)
+
+// keepSFULease relays media while this instance holds the relay's lease and
+// keeps trying to take it over while another one does. The relay keeps its
+// rooms in memory, so relaying on two replicas at once would split every
+// meeting in two.
+func (h *Hub) keepSFULease() {
+	ticker := time.NewTicker(sfuLeaseTTL / 3)
+	defer ticker.Stop()
+
+	var heldAt time.Time
+	for {
+		now := time.Now()
+		held, err := h.broker.holdLease(sfuLeaseKey, sfuLeaseTTL)
+		switch {
+		case err != nil:
+			// The lease can't be renewed, it is gone once it expires
+			log.Printf("error renewing the media relay lease: %v", err)
+			if !heldAt.IsZero() && now.Sub(heldAt) >= sfuLeaseTTL {
+				heldAt = time.Time{}
+				h.stopRelaying()
+			}
+		case held:
+			if heldAt.IsZero() {
+				log.Printf("relaying media for every replica")
+				h.mediaMutex.Lock()
+				h.relaying = true
+				h.mediaMutex.Unlock()
+			}
+			heldAt = now
+		case !heldAt.IsZero():
+			heldAt = time.Time{}
+			h.stopRelaying()
+		}
+
+		<-ticker.C
+	}
+}
+
+// stopRelaying disconnects everyone from the media relay once this instance
+// lost its lease, telling the clients to connect to each other directly
+func (h *Hub) stopRelaying() {
+	log.Printf("lost the media relay lease, clients connect to each other directly")
+
+	h.mediaMutex.Lock()
+	h.relaying = false
+	h.media.LeaveAll()
+	h.mediaMutex.Unlock()
+
+	h.roomsMutex.Lock()
+	rooms := make([]*roomHub, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.roomsMutex.Unlock()
+
+	for _, room := range rooms {
+		msg := Message{
+			Type:      TypeSFULeave,
+			RoomID:    room.id,
+			Content:   "media relay has stopped, connect to peers directly",
+			Timestamp: time.Now(),
+		}
+		jsonMsg, _ := json.Marshal(msg)
+		room.post(envelope{RoomID: room.id, Ephemeral: true, Data: jsonMsg})
+	}
+}
+
+// clientSignaler delivers the media relay's offers and ICE candidates to
+// the connection that joined it. They never leave this instance, the relay
+// only forwards media between participants connected to it.
+type clientSignaler struct {
+	client *Client
+}
+
+func (s clientSignaler) Offer(offer webrtc.SessionDescription) {
+	s.send(TypeSFUOffer, offer)
+}
+
+func (s clientSignaler) Candidate(candidate webrtc.ICECandidateInit) {
+	s.send(TypeSFUCandidate, candidate)
+}
+
+func (s clientSignaler) send(msgType MessageType, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	msg := Message{
+		Type:      msgType,
+		RoomID:    s.client.roomID,
+		UserID:    s.client.userID,
+		Timestamp: time.Now(),
+		Payload:   data,
+	}
+	jsonMsg, _ := json.Marshal(msg)
+
+	// Goes through the actor, which is the only writer to client.send
+	s.client.room.post(envelope{RoomID: s.client.roomID, TargetClientID: s.client.id, Data: jsonMsg})
+}
+
+// handleMedia passes a client's media relay signaling on to the SFU
+func (h *Hub) handleMedia(msg *Message, sender *Client) {
+	h.mediaMutex.RLock()
+	defer h.mediaMutex.RUnlock()
+
+	if !h.relaying {
+		h.sendError(sender, "media relay is not enabled, connect to peers directly")
+		return
+	}
+
+	var err error
+	switch msg.Type {
+	case TypeSFUJoin:
+		err = h.media.Join(sender.roomID, sender.userID, sender.id, clientSignaler{client: sender})
+
+	case TypeSFUAnswer:
+		var answer webrtc.SessionDescription
+		if err := json.Unmarshal(msg.Payload, &answer); err != nil {
+			h.sendError(sender, "sfu_answer requires a session description")
+			return
+		}
+		err = h.media.Answer(sender.roomID, sender.id, answer)
+
+	case TypeSFUCandidate:
+		var candidate webrtc.ICECandidateInit
+		if err := json.Unmarshal(msg.Payload, &candidate); err != nil {
+			h.sendError(sender, "sfu_candidate requires an ICE candidate")
+			return
+		}
+		err = h.media.AddCandidate(sender.roomID, sender.id, candidate)
+
+	case TypeSFULeave:
+		h.media.Leave(sender.roomID, sender.id)
+	}
+
+	if err != nil {
+		h.sendError(sender, err.Error())
+	}
+}
@@ -0,0 +1,256 @@
+package websockets
+
+import (
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Lock states carried in the Content of TypeLock messages
+const (
+	MeetingLocked   = "locked"
+	MeetingUnlocked = "unlocked"
+)
+
+const (
+	lockKeyPrefix = "ws:lock:"
+
+	// lockTTL releases a lock left behind by an instance that went away
+	// before the meeting ended
+	lockTTL = 12 * time.Hour
+)
+
+func lockKey(roomID string) string {
+	return lockKeyPrefix + roomID
+}
+
+// SetLocked locks or unlocks the room for every instance
+func (b *Broker) SetLocked(roomID string, locked bool) error {
+	if !locked {
+		return b.client.Del(b.ctx, lockKey(roomID)).Err()
+	}
+	return b.client.Set(b.ctx, lockKey(roomID), 1, lockTTL).Err()
+}
+
+// IsLocked reports whether the room is locked on any instance
+func (b *Broker) IsLocked(roomID string) (bool, error) {
+	err := b.client.Get(b.ctx, lockKey(roomID)).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// IsRoomLocked reports whether a moderator locked the live meeting against
+// new participants
+func (h *Hub) IsRoomLocked(roomID string) bool {
+	if h.broker != nil {
+		locked, err := h.broker.IsLocked(roomID)
+		if err == nil {
+			return locked
+		}
+		log.Printf("error reading lock of room %s: %v", roomID, err)
+	}
+
+	room := h.getRoom(roomID)
+	if room == nil {
+		return false
+	}
+
+	locked := false
+	room.do(func() {
+		locked = room.locked
+	})
+	return locked
+}
+
+func (h *Hub) setLocked(room *roomHub, locked bool) error {
+	if h.broker != nil {
+		return h.broker.SetLocked(room.id, locked)
+	}
+
+	room.do(func() {
+		room.locked = locked
+	})
+	return nil
+}
+
+// evict disconnects a client for good. Kicked participants are announced
+// as gone right away, without holding their seat for a reconnect.
+func (r *roomHub) evict(client *Client, announce bool) {
+	r.remove(client)
+	client.evicted = true
+	if !r.removePresence(client.userID) {
+		return
+	}
+	r.participantLeft(client.userID, time.Now())
+	if announce {
+		r.announce(TypeUserLeft, client)
+	}
+}
+
+// clear empties the room once the meeting was ended for everyone, turning
+// away the lobby and dropping seats held for reconnects
+func (r *roomHub) clear(data []byte) {
+	for client := range r.waiting {
+		r.send(client, 0, data)
+		r.remove(client)
+	}
+
+	for userID, timer := range r.leaving {
+		delete(r.leaving, userID)
+		if r.removePresence(userID) {
+			r.participantLeft(userID, time.Now())
+		}
+		if timer.Stop() {
+			r.hub.releaseRoom(r)
+		}
+	}
+}
+
+// end resets the meeting state once the last participant is gone
+func (r *roomHub) end() {
+	r.locked = false
+	if r.hub.broker != nil {
+		if err := r.hub.broker.SetLocked(r.id, false); err != nil {
+			log.Printf("error unlocking room %s: %v", r.id, err)
+		}
+	}
+	r.finish()
+	r.hub.meetingEnded(r.id)
+}
+
+// handleModeration runs a moderation command after checking the sender's
+// current role, so a demoted moderator loses the controls right away
+func (h *Hub) handleModeration(msg *Message, sender *Client) {
+	allowed, err := h.store.HasRoomPermission(sender.roomID, sender.userID, PermModerateChat)
+	if err != nil {
+		h.sendError(sender, err.Error())
+		return
+	}
+	if !allowed {
+		h.sendError(sender, "only moderators can do this")
+		return
+	}
+
+	switch msg.Type {
+	case TypeKick:
+		h.kick(msg, sender)
+	case TypeMute:
+		h.mute(msg, sender)
+	case TypeLock:
+		h.lock(msg, sender)
+	case TypeEndMeeting:
+		h.endMeeting(msg, sender)
+	}
+}
+
+// kick disconnects every connection of the target, banning them first when
+// asked to. Only participants below the sender's own role can be kicked.
+func (h *Hub) kick(msg *Message, sender *Client) {
+	if msg.TargetID == "" || msg.TargetID == sender.userID {
+		h.sendError(sender, "kick requires the targetId of another participant")
+		return
+	}
+
+	outranks, err := h.store.OutranksMember(sender.roomID, sender.userID, msg.TargetID)
+	if err != nil {
+		h.sendError(sender, err.Error())
+		return
+	}
+	if !outranks {
+		h.sendError(sender, "you can only remove participants below your own role")
+		return
+	}
+
+	if msg.Metadata.Ban {
+		if err := h.store.BanFromRoom(sender.roomID, msg.TargetID, sender.userID, msg.Content); err != nil {
+			h.sendError(sender, err.Error())
+			return
+		}
+	}
+
+	h.Kick(sender.roomID, msg.TargetID, msg.Content, msg.Metadata.Ban)
+}
+
+// Kick tells the user why they were removed and disconnects all of their
+// connections to the room
+func (h *Hub) Kick(roomID, userID, reason string, banned bool) {
+	for _, fn := range h.kickedHooks {
+		fn(roomID, userID)
+	}
+
+	kicked := Message{
+		Type:      TypeKicked,
+		RoomID:    roomID,
+		UserID:    userID,
+		Content:   reason,
+		Timestamp: time.Now(),
+		Metadata: Metadata{
+			Ban: banned,
+		},
+	}
+	h.publishMessage(&kicked, envelope{RoomID: roomID, TargetUserID: userID, Evict: true})
+}
+
+// mute asks the target, or everyone below the sender's role, to mute their
+// microphone. Like kicks, mutes only reach participants the sender outranks.
+func (h *Hub) mute(msg *Message, sender *Client) {
+	env := envelope{RoomID: sender.roomID, Ephemeral: true}
+	if msg.TargetID != "" {
+		outranks, err := h.store.OutranksMember(sender.roomID, sender.userID, msg.TargetID)
+		if err != nil {
+			h.sendError(sender, err.Error())
+			return
+		}
+		if !outranks {
+			h.sendError(sender, "you can only mute participants below your own role")
+			return
+		}
+		env.TargetUserID = msg.TargetID
+	} else {
+		roles, err := h.store.RolesOutranked(sender.roomID, sender.userID)
+		if err != nil {
+			h.sendError(sender, err.Error())
+			return
+		}
+		// An empty list would reach everyone
+		if len(roles) == 0 {
+			h.sendError(sender, "you can only mute participants below your own role")
+			return
+		}
+		env.Roles = roles
+		env.SkipClientID = sender.id
+	}
+
+	h.publishMessage(msg, env)
+}
+
+// lock keeps new participants out of the meeting until it is unlocked or ends
+func (h *Hub) lock(msg *Message, sender *Client) {
+	if msg.Content != MeetingLocked && msg.Content != MeetingUnlocked {
+		h.sendError(sender, "lock content must be locked or unlocked")
+		return
+	}
+
+	if err := h.setLocked(sender.room, msg.Content == MeetingLocked); err != nil {
+		log.Printf("error locking room %s: %v", sender.roomID, err)
+		h.sendError(sender, "unable to lock the meeting")
+		return
+	}
+
+	h.publishMessage(msg, envelope{RoomID: sender.roomID})
+}
+
+// endMeeting tells everyone the meeting is over and disconnects them
+func (h *Hub) endMeeting(msg *Message, sender *Client) {
+	ended := Message{
+		Type:      TypeMeetingEnded,
+		RoomID:    sender.roomID,
+		UserID:    sender.userID,
+		Content:   msg.Content,
+		Timestamp: time.Now(),
+	}
+	h.publishMessage(&ended, envelope{RoomID: sender.roomID, Ephemeral: true, Evict: true})
+}
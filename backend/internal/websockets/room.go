@@ -0,0 +1,411 @@
+package websockets
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"time"
+)
+
+// roomBufferSize bounds how many envelopes can queue up for a room actor
+const roomBufferSize = 256
+
+// roomHub is the actor that owns a single room. Only its goroutine touches
+// the client set or writes to a client's send channel, so a slow consumer is
+// closed and removed exactly once without any locking.
+type roomHub struct {
+	id  string
+	hub *Hub
+
+	// Clients connected to this room on this instance
+	clients map[*Client]bool
+
+	// Clients waiting in the lobby, they only get messages addressed to them
+	waiting map[*Client]bool
+
+	// Seats per user on this instance: live connections, or one held seat
+	// while the user's departure is in its grace period
+	users map[string]int
+
+	// Pending user_left announcements, cancelled by a reconnect
+	leaving map[string]*time.Timer
+
+	// Whether new participants are kept out, only used without a broker
+	locked bool
+
+	// ID of the meeting session in progress, empty while nobody is here
+	session string
+
+	// Call quality reports of the meeting in progress
+	telemetry *meetingTelemetry
+
+	// Dominant speaker detection of the meeting in progress. With a broker
+	// only the instance holding the room's speaker lease runs it, until
+	// detectUntil when the lease is checked again.
+	speakers    *speakerDetector
+	detecting   bool
+	detectUntil time.Time
+
+	// The active speaker as last announced to the room
+	speaker string
+
+	// Replay buffer, only used without a broker
+	history backlog
+
+	register   chan *Client
+	unregister chan *Client
+	broadcast  chan envelope
+
+	// Functions executed inside the actor, used for read-only queries
+	calls chan func()
+
+	// Closed by the hub once the last client has been released
+	stop chan struct{}
+
+	// Number of clients and pending departures holding the room, guarded
+	// by Hub.roomsMutex
+	refs int
+}
+
+func newRoomHub(hub *Hub, id string) *roomHub {
+	return &roomHub{
+		id:         id,
+		hub:        hub,
+		clients:    make(map[*Client]bool),
+		waiting:    make(map[*Client]bool),
+		users:      make(map[string]int),
+		leaving:    make(map[string]*time.Timer),
+		telemetry:  newMeetingTelemetry(),
+		speakers:   newSpeakerDetector(),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		broadcast:  make(chan envelope, roomBufferSize),
+		calls:      make(chan func()),
+		stop:       make(chan struct{}),
+	}
+}
+
+func (r *roomHub) run() {
+	for {
+		select {
+		case client := <-r.register:
+			r.join(client)
+
+		case client := <-r.unregister:
+			r.leave(client)
+
+		case env := <-r.broadcast:
+			r.deliver(env)
+
+		case fn := <-r.calls:
+			fn()
+
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// post queues an envelope for delivery, dropping it if the room has shut down
+func (r *roomHub) post(env envelope) {
+	select {
+	case r.broadcast <- env:
+	case <-r.stop:
+	}
+}
+
+// do runs fn inside the actor and waits for it to finish. It returns false
+// when the room has already shut down.
+func (r *roomHub) do(fn func()) bool {
+	done := make(chan struct{})
+	select {
+	case r.calls <- func() { fn(); close(done) }:
+	case <-r.stop:
+		return false
+	}
+	<-done
+	return true
+}
+
+func (r *roomHub) join(client *Client) {
+	if client.lobby.Load() {
+		r.waiting[client] = true
+		r.knock(client, KnockWaiting)
+		return
+	}
+
+	if client.lastSeq > 0 {
+		r.replay(client)
+	}
+	r.clients[client] = true
+	r.showLobby(client)
+	r.showQuality(client)
+	r.showSpeaker(client)
+
+	// Back within the grace period, the held seat is taken over silently
+	if timer, ok := r.leaving[client.userID]; ok {
+		delete(r.leaving, client.userID)
+		if timer.Stop() {
+			r.hub.releaseRoom(r)
+		}
+		r.participantRejoined(client)
+		return
+	}
+
+	if len(r.users) == 0 {
+		r.begin()
+	}
+	if r.addPresence(client) {
+		r.participantJoined(client)
+		r.announce(TypeUserJoined, client)
+	}
+}
+
+func (r *roomHub) leave(client *Client) {
+	// Only released once a held seat has its own reference, so the room
+	// can't shut down in between
+	defer r.hub.releaseRoom(r)
+
+	// Evicted clients gave up their seat already
+	if client.evicted {
+		return
+	}
+
+	waiting := r.waiting[client]
+	r.remove(client)
+
+	// Lobby clients never took a seat
+	if client.lobby.Load() {
+		if waiting {
+			r.knock(client, KnockLeft)
+		}
+		return
+	}
+
+	if r.users[client.userID] > 1 {
+		r.removePresence(client.userID)
+		return
+	}
+
+	// Last connection of the user here. Keep the seat for a moment so a
+	// reconnect doesn't show up as user_left followed by user_joined.
+	leftAt := time.Now()
+	r.hub.retainRoom(r)
+	var timer *time.Timer
+	timer = time.AfterFunc(leaveGracePeriod, func() {
+		r.do(func() {
+			if r.leaving[client.userID] != timer {
+				return
+			}
+			delete(r.leaving, client.userID)
+			if r.removePresence(client.userID) {
+				r.participantLeft(client.userID, leftAt)
+				r.announce(TypeUserLeft, client)
+			}
+			if r.empty() {
+				r.end()
+			}
+		})
+		r.hub.releaseRoom(r)
+	})
+	r.leaving[client.userID] = timer
+}
+
+// addPresence counts a new connection and reports whether it is the user's
+// first one in the room, across all instances when a broker is configured
+func (r *roomHub) addPresence(client *Client) bool {
+	r.users[client.userID]++
+	if r.hub.broker == nil {
+		return r.users[client.userID] == 1
+	}
+
+	count, err := r.hub.broker.AddPresence(r.id, client.userID)
+	if err != nil {
+		log.Printf("error tracking presence in room %s: %v", r.id, err)
+		return r.users[client.userID] == 1
+	}
+	return count == 1
+}
+
+// removePresence drops a connection and reports whether it was the user's last
+func (r *roomHub) removePresence(userID string) bool {
+	r.users[userID]--
+	local := r.users[userID]
+	if local <= 0 {
+		delete(r.users, userID)
+	}
+	if r.hub.broker == nil {
+		return local <= 0
+	}
+
+	count, err := r.hub.broker.RemovePresence(r.id, userID)
+	if err != nil {
+		log.Printf("error tracking presence in room %s: %v", r.id, err)
+		return local <= 0
+	}
+	return count == 0
+}
+
+// empty reports whether nobody is left in the room on any instance, seats
+// held for reconnects included
+func (r *roomHub) empty() bool {
+	if len(r.users) > 0 || len(r.leaving) > 0 {
+		return false
+	}
+	if r.hub.broker == nil {
+		return true
+	}
+
+	count, err := r.hub.broker.PresentUsers(r.id)
+	if err != nil {
+		log.Printf("error reading presence of room %s: %v", r.id, err)
+		return false
+	}
+	return count == 0
+}
+
+// remove drops a client and closes its send channel if it is still attached
+func (r *roomHub) remove(client *Client) {
+	if !r.clients[client] && !r.waiting[client] {
+		return
+	}
+	delete(r.clients, client)
+	delete(r.waiting, client)
+	close(client.send)
+}
+
+// deliver writes an envelope to the matching local clients
+func (r *roomHub) deliver(env envelope) {
+	if env.Speech {
+		r.hearAudio(env)
+		return
+	}
+	if env.Lobby != "" {
+		r.settleLobby(env)
+		return
+	}
+	if env.Speaker != "" {
+		r.speaker = env.Speaker
+	}
+
+	if r.hub.broker == nil && env.sequenced() {
+		env = r.history.append(env)
+	}
+	data := withSeq(env.Data, env.Seq)
+
+	for client := range r.clients {
+		if client.id == env.SkipClientID {
+			continue
+		}
+		if env.TargetUserID != "" && client.userID != env.TargetUserID {
+			continue
+		}
+		if env.TargetClientID != "" && client.id != env.TargetClientID {
+			continue
+		}
+		if len(env.Roles) > 0 && !slices.Contains(env.Roles, client.role) {
+			continue
+		}
+		r.send(client, env.Seq, data)
+		if env.Evict {
+			r.evict(client, env.TargetUserID != "")
+		}
+	}
+
+	if env.Evict {
+		if env.TargetUserID == "" && env.TargetClientID == "" {
+			r.clear(data)
+		}
+		if r.empty() {
+			r.end()
+		}
+		return
+	}
+
+	// Waiting clients only get what is sent to them directly
+	if env.TargetClientID != "" {
+		for client := range r.waiting {
+			if client.id == env.TargetClientID {
+				r.send(client, env.Seq, data)
+			}
+		}
+	}
+}
+
+// send writes to a single client, skipping events it already received
+func (r *roomHub) send(client *Client, seq uint64, data []byte) {
+	if seq != 0 {
+		if seq <= client.lastSeq {
+			return
+		}
+		client.lastSeq = seq
+	}
+
+	select {
+	case client.send <- data:
+	default:
+		// Slow consumer, its pumps shut down once send is closed
+		r.remove(client)
+	}
+}
+
+// replay sends a resuming client the events it missed, or asks it to
+// resync when they are no longer buffered
+func (r *roomHub) replay(client *Client) {
+	var missed []envelope
+	var complete bool
+	if r.hub.broker == nil {
+		missed, complete = r.history.since(client.lastSeq)
+	} else {
+		var err error
+		missed, complete, err = r.hub.broker.Since(r.id, client.lastSeq)
+		if err != nil {
+			log.Printf("error reading history of room %s: %v", r.id, err)
+		}
+	}
+
+	if !complete {
+		resync := Message{
+			Type:      TypeResync,
+			RoomID:    r.id,
+			UserID:    client.userID,
+			Content:   "missed events are no longer available",
+			Timestamp: time.Now(),
+		}
+		jsonMsg, _ := json.Marshal(resync)
+		r.send(client, 0, jsonMsg)
+		client.lastSeq = 0
+	}
+
+	for _, env := range missed {
+		if env.SkipClientID == client.id {
+			continue
+		}
+		r.send(client, env.Seq, withSeq(env.Data, env.Seq))
+	}
+}
+
+// emit sends an envelope generated by the actor itself. Without a broker it
+// is delivered in place, as posting to our own channel could deadlock.
+func (r *roomHub) emit(env envelope) {
+	if r.hub.broker == nil {
+		r.deliver(env)
+		return
+	}
+	r.hub.publish(env)
+}
+
+func (r *roomHub) announce(msgType MessageType, client *Client) {
+	msg := Message{
+		Type:      msgType,
+		RoomID:    r.id,
+		UserID:    client.userID,
+		Timestamp: time.Now(),
+		Metadata: Metadata{
+			UserName: client.userName,
+			IsGuest:  client.guest,
+		},
+	}
+	jsonMsg, _ := json.Marshal(msg)
+	r.emit(envelope{RoomID: r.id, Data: jsonMsg})
+}
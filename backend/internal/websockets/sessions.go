@@ -0,0 +1,284 @@
+package websockets
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	meetingKeyPrefix = "ws:meeting:"
+
+	// meetingTTL forgets a meeting left behind by an instance that went
+	// away before it ended
+	meetingTTL = 12 * time.Hour
+
+	// sessionBufferSize bounds how many session events can wait for the store
+	sessionBufferSize = 1024
+
+	// sessionQueueTimeout is how long a join, leave or end waits for room in
+	// a full queue before it is given up to the sweeper
+	sessionQueueTimeout = 5 * time.Second
+
+	// sessionSweepInterval is how often sessions nobody is connected to any
+	// more are closed, and how old they have to be
+	sessionSweepInterval = time.Minute
+)
+
+func meetingKey(roomID string) string {
+	return meetingKeyPrefix + roomID
+}
+
+// StartMeeting returns the room's current meeting, making sessionID the
+// current one if there is none. created reports which of the two happened.
+func (b *Broker) StartMeeting(roomID, sessionID string) (current string, created bool, err error) {
+	created, err = b.client.SetNX(b.ctx, meetingKey(roomID), sessionID, meetingTTL).Result()
+	if err != nil || created {
+		return sessionID, created, err
+	}
+
+	current, err = b.client.Get(b.ctx, meetingKey(roomID)).Result()
+	return current, false, err
+}
+
+// CurrentMeeting returns the room's current meeting, or "" when there is none
+func (b *Broker) CurrentMeeting(roomID string) (string, error) {
+	current, err := b.client.Get(b.ctx, meetingKey(roomID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return current, err
+}
+
+// EndMeeting forgets the room's current meeting if it is still sessionID
+func (b *Broker) EndMeeting(roomID, sessionID string) error {
+	current, err := b.client.Get(b.ctx, meetingKey(roomID)).Result()
+	if err != nil || current != sessionID {
+		return nil
+	}
+	return b.client.Del(b.ctx, meetingKey(roomID)).Err()
+}
+
+type sessionEventKind int
+
+const (
+	sessionStarted sessionEventKind = iota
+	sessionEnded
+	participantJoined
+	participantRejoined
+	participantLeft
+	participantTelemetry
+)
+
+// sessionEvent is a change to a meeting session waiting to be stored
+type sessionEvent struct {
+	kind      sessionEventKind
+	roomID    string
+	sessionID string
+	userID    string
+	userName  string
+	guest     bool
+	telemetry *TelemetryTotals
+	at        time.Time
+}
+
+// recordSession queues an event for the store. Telemetry is dropped when the
+// store falls behind, but the other events wait for room in the queue, since
+// losing one leaves a session open. Whatever still gets lost is closed by
+// sweepSessions.
+func (h *Hub) recordSession(event sessionEvent) {
+	select {
+	case h.sessionEvents <- event:
+		return
+	default:
+	}
+
+	if event.kind == participantTelemetry {
+		log.Printf("dropping telemetry for room %s, the store is falling behind", event.roomID)
+		return
+	}
+
+	timeout := time.NewTimer(sessionQueueTimeout)
+	defer timeout.Stop()
+
+	select {
+	case h.sessionEvents <- event:
+	case <-timeout.C:
+		log.Printf("dropping session event for room %s, the store is falling behind", event.roomID)
+	}
+}
+
+// recordSessions stores session events one at a time, so a participant is
+// never written before the meeting they joined
+func (h *Hub) recordSessions() {
+	for event := range h.sessionEvents {
+		var err error
+		switch event.kind {
+		case sessionStarted:
+			err = h.store.StartMeetingSession(event.roomID, event.sessionID, event.at)
+		case sessionEnded:
+			err = h.store.EndMeetingSession(event.roomID, event.sessionID, event.at)
+		case participantJoined:
+			err = h.store.JoinMeetingSession(event.roomID, event.sessionID, event.userID, event.userName, event.guest, event.at)
+		case participantRejoined:
+			err = h.store.RejoinMeetingSession(event.roomID, event.sessionID, event.userID)
+		case participantLeft:
+			err = h.store.LeaveMeetingSession(event.roomID, event.sessionID, event.userID, event.at)
+		case participantTelemetry:
+			err = h.store.RecordMeetingTelemetry(event.roomID, event.sessionID, event.userID, *event.telemetry)
+		}
+		if err != nil {
+			log.Printf("error recording session of room %s: %v", event.roomID, err)
+		}
+	}
+}
+
+// sweepSessions periodically closes what the store still has open although
+// nobody is connected to it anymore
+func (h *Hub) sweepSessions() {
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.sweepOpenSessions(time.Now().Add(-sessionSweepInterval))
+	}
+}
+
+// sweepOpenSessions ends the meetings started before the given time that are
+// no longer their room's current one or that nobody is in anymore, and closes
+// the stretches of participants who left without it being recorded. The
+// closing events go through the queue, behind anything still on its way.
+func (h *Hub) sweepOpenSessions(before time.Time) {
+	sessions, err := h.store.OpenMeetingSessions(before)
+	if err != nil {
+		log.Printf("error reading open meeting sessions: %v", err)
+		return
+	}
+
+	for _, session := range sessions {
+		current := h.currentMeeting(session.RoomID)
+		if (current != "" && current != session.ID) || h.RoomUserCount(session.RoomID) == 0 {
+			if h.broker != nil {
+				if err := h.broker.EndMeeting(session.RoomID, session.ID); err != nil {
+					log.Printf("error ending meeting in room %s: %v", session.RoomID, err)
+				}
+			}
+			h.recordSession(sessionEvent{kind: sessionEnded, roomID: session.RoomID, sessionID: session.ID, at: time.Now()})
+			continue
+		}
+
+		users, err := h.store.OpenSessionParticipants(session.ID, before)
+		if err != nil {
+			log.Printf("error reading participants of room %s: %v", session.RoomID, err)
+			continue
+		}
+		for _, userID := range users {
+			if !h.IsUserInRoom(session.RoomID, userID) {
+				h.recordSession(sessionEvent{kind: participantLeft, roomID: session.RoomID, sessionID: session.ID, userID: userID, at: time.Now()})
+			}
+		}
+	}
+}
+
+// currentMeeting returns the room's meeting in progress, or "" when there is
+// none or it isn't known
+func (h *Hub) currentMeeting(roomID string) string {
+	if h.broker != nil {
+		current, err := h.broker.CurrentMeeting(roomID)
+		if err == nil {
+			return current
+		}
+		log.Printf("error reading meeting of room %s: %v", roomID, err)
+	}
+
+	room := h.getRoom(roomID)
+	if room == nil {
+		return ""
+	}
+
+	current := ""
+	room.do(func() {
+		current = room.session
+	})
+	return current
+}
+
+// begin picks up the room's meeting when the first participant arrives on
+// this instance, starting a new one if nobody is in it anywhere else
+func (r *roomHub) begin() {
+	sessionID := uuid.NewString()
+	created := true
+	if r.hub.broker != nil {
+		current, isNew, err := r.hub.broker.StartMeeting(r.id, sessionID)
+		if err != nil {
+			log.Printf("error starting meeting in room %s: %v", r.id, err)
+		} else {
+			sessionID, created = current, isNew
+		}
+	}
+
+	r.session = sessionID
+	r.telemetry = newMeetingTelemetry()
+	r.loadSpeaker()
+	if created {
+		r.hub.recordSession(sessionEvent{kind: sessionStarted, roomID: r.id, sessionID: sessionID, at: time.Now()})
+	}
+}
+
+// finish ends the meeting once the last participant is gone everywhere
+func (r *roomHub) finish() {
+	if r.session == "" {
+		return
+	}
+
+	if r.hub.broker != nil {
+		if err := r.hub.broker.EndMeeting(r.id, r.session); err != nil {
+			log.Printf("error ending meeting in room %s: %v", r.id, err)
+		}
+	}
+	r.hub.recordSession(sessionEvent{kind: sessionEnded, roomID: r.id, sessionID: r.session, at: time.Now()})
+	r.session = ""
+	r.telemetry = newMeetingTelemetry()
+	r.loadSpeaker()
+}
+
+func (r *roomHub) participantJoined(client *Client) {
+	r.hub.recordSession(sessionEvent{
+		kind:      participantJoined,
+		roomID:    r.id,
+		sessionID: r.session,
+		userID:    client.userID,
+		userName:  client.userName,
+		guest:     client.guest,
+		at:        time.Now(),
+	})
+}
+
+// participantRejoined records a reconnect that took over the user's held
+// seat, keeping them in the same stretch of the meeting
+func (r *roomHub) participantRejoined(client *Client) {
+	r.hub.recordSession(sessionEvent{
+		kind:      participantRejoined,
+		roomID:    r.id,
+		sessionID: r.session,
+		userID:    client.userID,
+		at:        time.Now(),
+	})
+}
+
+// participantLeft records when the user's last connection went away, which
+// for a dropped connection is before its grace period ran out
+func (r *roomHub) participantLeft(userID string, at time.Time) {
+	r.flushTelemetry(userID)
+	r.forgetSpeaker(userID)
+	r.hub.recordSession(sessionEvent{
+		kind:      participantLeft,
+		roomID:    r.id,
+		sessionID: r.session,
+		userID:    userID,
+		at:        at,
+	})
+}
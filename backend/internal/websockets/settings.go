@@ -0,0 +1,80 @@
+package websockets
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+	"video-chat/internal/models"
+)
+
+// Screen share states carried in the Content of TypeScreenShare messages
+const (
+	ScreenShareStarted = "started"
+	ScreenShareStopped = "stopped"
+)
+
+// roomSettings is the part of a room clients apply live. Field names match
+// the REST representation of models.Room.
+type roomSettings struct {
+	AllowChat        bool `json:"allow_chat"`
+	AllowScreenShare bool `json:"allow_screen_share"`
+	MuteOnEntry      bool `json:"mute_on_entry"`
+	RequirePassword  bool `json:"require_password"`
+	MaxUsers         int  `json:"maxUsers"`
+}
+
+func settingsMessage(room *models.Room) *Message {
+	payload, _ := json.Marshal(roomSettings{
+		AllowChat:        room.AllowChat,
+		AllowScreenShare: room.AllowScreenShare,
+		MuteOnEntry:      room.MuteOnEntry,
+		RequirePassword:  room.RequirePassword,
+		MaxUsers:         room.MaxUsers,
+	})
+
+	return &Message{
+		Type:      TypeRoomSettingsUpdated,
+		RoomID:    room.ID,
+		Timestamp: time.Now(),
+		Payload:   payload,
+	}
+}
+
+// PublishRoomSettings tells everyone in the room about changed settings
+func (h *Hub) PublishRoomSettings(room *models.Room) {
+	h.publishMessage(settingsMessage(room), envelope{RoomID: room.ID})
+}
+
+// SendRoomSettings gives a freshly registered client the settings it has to
+// apply on entry, such as starting muted
+func (h *Hub) SendRoomSettings(client *Client, room *models.Room) {
+	jsonMsg, err := json.Marshal(settingsMessage(room))
+	if err != nil {
+		log.Printf("error marshaling message: %v", err)
+		return
+	}
+	client.room.post(envelope{RoomID: client.roomID, TargetClientID: client.id, Data: jsonMsg})
+}
+
+// handleScreenShare relays screen share state, refusing to start one when
+// the room does not allow it
+func (h *Hub) handleScreenShare(msg *Message, sender *Client) {
+	switch msg.Content {
+	case ScreenShareStarted:
+		room, err := h.store.GetRoom(sender.roomID)
+		if err != nil {
+			h.sendError(sender, err.Error())
+			return
+		}
+		if !room.AllowScreenShare {
+			h.sendError(sender, "screen sharing is disabled in this room")
+			return
+		}
+	case ScreenShareStopped:
+	default:
+		h.sendError(sender, "screen_share content must be started or stopped")
+		return
+	}
+
+	h.publishMessage(msg, envelope{RoomID: msg.RoomID})
+}
@@ -0,0 +1,288 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// minAudioInterval drops audio levels sent more often than this
+	minAudioInterval = 50 * time.Millisecond
+
+	// levelSmoothing weighs a new audio level against the running one, so
+	// a cough or a clap doesn't take the floor
+	levelSmoothing = 0.4
+
+	// speechThreshold is the smoothed level that counts as speaking
+	speechThreshold = 0.05
+
+	// staleLevel is how long a level counts after the last report, clients
+	// stop sending while muted
+	staleLevel = 1500 * time.Millisecond
+
+	// A challenger replaces the active speaker once they have been louder
+	// by switchMargin for switchDelay, and never sooner than minSpeakerHold
+	// after the last change
+	switchMargin   = 1.5
+	switchDelay    = 400 * time.Millisecond
+	minSpeakerHold = time.Second
+
+	speakerLeaseKeyPrefix = "ws:speaker-lease:"
+	speakerKeyPrefix      = "ws:speaker:"
+
+	// speakerLeaseTTL is how long an instance runs a room's detection
+	// without renewing its lease. It is renewed, or another instance tries
+	// to take it over, every third of that.
+	speakerLeaseTTL = 3 * time.Second
+)
+
+// audioSample is what an audio_level message relays to every instance
+// running the room. Left reports a participant who left the meeting instead.
+type audioSample struct {
+	UserID string  `json:"userId"`
+	Level  float64 `json:"level,omitempty"`
+	Left   bool    `json:"left,omitempty"`
+}
+
+func speakerLeaseKey(roomID string) string {
+	return speakerLeaseKeyPrefix + roomID
+}
+
+func speakerKey(sessionID string) string {
+	return speakerKeyPrefix + sessionID
+}
+
+// HoldSpeakerLease reports whether this instance runs the room's speaker
+// detection. Only one instance does, so they can't disagree about who has
+// the floor.
+func (b *Broker) HoldSpeakerLease(roomID string) (bool, error) {
+	return b.holdLease(speakerLeaseKey(roomID), speakerLeaseTTL)
+}
+
+// SetSpeaker keeps the meeting's active speaker for instances joining it later
+func (b *Broker) SetSpeaker(sessionID, userID string) error {
+	return b.client.Set(b.ctx, speakerKey(sessionID), userID, meetingTTL).Err()
+}
+
+// Speaker returns the meeting's active speaker, or "" when nobody has the floor
+func (b *Broker) Speaker(sessionID string) (string, error) {
+	userID, err := b.client.Get(b.ctx, speakerKey(sessionID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return userID, err
+}
+
+type speakerLevel struct {
+	level float64
+	heard time.Time
+}
+
+// speakerDetector picks the room's dominant speaker from the participants'
+// audio levels. Hysteresis keeps the floor with the current speaker until
+// someone else has clearly been louder for a while.
+type speakerDetector struct {
+	levels map[string]*speakerLevel
+
+	// The active speaker and when they took the floor
+	dominant string
+	since    time.Time
+
+	// Who is currently louder than the active speaker, and since when
+	challenger      string
+	challengerSince time.Time
+}
+
+func newSpeakerDetector() *speakerDetector {
+	return &speakerDetector{levels: make(map[string]*speakerLevel)}
+}
+
+// level returns the user's smoothed level, zero once it has gone stale
+func (d *speakerDetector) level(userID string, now time.Time) float64 {
+	s, ok := d.levels[userID]
+	if !ok || now.Sub(s.heard) > staleLevel {
+		return 0
+	}
+	return s.level
+}
+
+// hear takes in an audio level and reports whether the dominant speaker
+// changed because of it
+func (d *speakerDetector) hear(userID string, level float64, now time.Time) bool {
+	s, ok := d.levels[userID]
+	if !ok {
+		s = &speakerLevel{}
+		d.levels[userID] = s
+	}
+	if now.Sub(s.heard) > staleLevel {
+		s.level = level
+	} else {
+		s.level = levelSmoothing*level + (1-levelSmoothing)*s.level
+	}
+	s.heard = now
+
+	loudest, loudestLevel := "", 0.0
+	for id := range d.levels {
+		if l := d.level(id, now); l > loudestLevel {
+			loudest, loudestLevel = id, l
+		}
+	}
+
+	if loudestLevel < speechThreshold || loudest == d.dominant {
+		d.challenger = ""
+		return false
+	}
+
+	if d.dominant != "" {
+		if now.Sub(d.since) < minSpeakerHold || loudestLevel < switchMargin*d.level(d.dominant, now) {
+			d.challenger = ""
+			return false
+		}
+
+		if loudest != d.challenger {
+			d.challenger, d.challengerSince = loudest, now
+			return false
+		}
+		if now.Sub(d.challengerSince) < switchDelay {
+			return false
+		}
+	}
+
+	d.dominant, d.since = loudest, now
+	d.challenger = ""
+	return true
+}
+
+// forget drops a participant who left. Nobody holds the floor after the
+// active speaker left until someone speaks up.
+func (d *speakerDetector) forget(userID string) {
+	delete(d.levels, userID)
+	if d.challenger == userID {
+		d.challenger = ""
+	}
+	if d.dominant == userID {
+		d.dominant = ""
+	}
+}
+
+// handleAudioLevel relays a participant's audio level to the room's speaker
+// detection on every instance
+func (h *Hub) handleAudioLevel(msg *Message, sender *Client) {
+	now := time.Now()
+	if now.Sub(sender.audioAt) < minAudioInterval {
+		return
+	}
+	sender.audioAt = now
+
+	var payload struct {
+		Level float64 `json:"level"`
+	}
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil || payload.Level < 0 || payload.Level > 1 {
+		h.sendError(sender, "audio_level requires a level between 0 and 1")
+		return
+	}
+
+	data, _ := json.Marshal(audioSample{UserID: sender.userID, Level: payload.Level})
+	h.publish(envelope{RoomID: sender.roomID, Ephemeral: true, Speech: true, Data: data})
+}
+
+// hearAudio feeds an audio level to the detection, if this instance runs it,
+// and announces it to the whole room when the active speaker changes
+func (r *roomHub) hearAudio(env envelope) {
+	var sample audioSample
+	if err := json.Unmarshal(env.Data, &sample); err != nil {
+		return
+	}
+	if sample.Left {
+		r.speakers.forget(sample.UserID)
+		if r.speaker == sample.UserID {
+			r.speaker = ""
+		}
+		return
+	}
+	if r.users[sample.UserID] == 0 && r.hub.broker == nil {
+		return
+	}
+
+	now := time.Now()
+	if r.detectsSpeaker(now) && r.speakers.hear(sample.UserID, sample.Level, now) {
+		if r.hub.broker != nil {
+			if err := r.hub.broker.SetSpeaker(r.session, sample.UserID); err != nil {
+				log.Printf("error storing active speaker of room %s: %v", r.id, err)
+			}
+		}
+		r.emit(envelope{RoomID: r.id, Ephemeral: true, Speaker: sample.UserID, Data: speakerMessage(r.id, sample.UserID)})
+	}
+}
+
+// detectsSpeaker reports whether this instance runs the room's speaker
+// detection, which without a broker it always does. An instance taking over
+// the lease carries on from the last announced speaker.
+func (r *roomHub) detectsSpeaker(now time.Time) bool {
+	if r.hub.broker == nil {
+		return true
+	}
+	if now.Before(r.detectUntil) {
+		return r.detecting
+	}
+
+	held, err := r.hub.broker.HoldSpeakerLease(r.id)
+	if err != nil {
+		log.Printf("error holding speaker lease of room %s: %v", r.id, err)
+	}
+	if held && !r.detecting {
+		r.speakers = newSpeakerDetector()
+		r.speakers.dominant, r.speakers.since = r.speaker, now
+	}
+	r.detecting = held
+	r.detectUntil = now.Add(speakerLeaseTTL / 3)
+	return held
+}
+
+// loadSpeaker picks up who has the floor in a meeting already in progress
+// on other instances
+func (r *roomHub) loadSpeaker() {
+	r.speakers = newSpeakerDetector()
+	r.speaker = ""
+	r.detecting = false
+	r.detectUntil = time.Time{}
+	if r.hub.broker == nil || r.session == "" {
+		return
+	}
+
+	speaker, err := r.hub.broker.Speaker(r.session)
+	if err != nil {
+		log.Printf("error reading active speaker of room %s: %v", r.id, err)
+		return
+	}
+	r.speaker = speaker
+}
+
+// forgetSpeaker tells the room's detection that a participant left
+func (r *roomHub) forgetSpeaker(userID string) {
+	data, _ := json.Marshal(audioSample{UserID: userID, Left: true})
+	r.emit(envelope{RoomID: r.id, Ephemeral: true, Speech: true, Data: data})
+}
+
+func speakerMessage(roomID, userID string) []byte {
+	msg := Message{
+		Type:      TypeActiveSpeaker,
+		RoomID:    roomID,
+		UserID:    userID,
+		Timestamp: time.Now(),
+	}
+	jsonMsg, _ := json.Marshal(msg)
+	return jsonMsg
+}
+
+// showSpeaker tells a newly joined client who has the floor
+func (r *roomHub) showSpeaker(client *Client) {
+	if r.speaker == "" {
+		return
+	}
+	r.send(client, 0, speakerMessage(r.id, r.speaker))
+}
@@ -0,0 +1,54 @@
+package websockets
+
+import (
+	"time"
+	"video-chat/internal/models"
+)
+
+// Permission names understood by Store.HasRoomPermission, matching the room
+// permission matrix
+const (
+	PermApproveJoins = "approve_joins"
+	PermModerateChat = "moderate_chat"
+)
+
+// Store persists room data on behalf of the hub. It is implemented by
+// room.RoomService and applies the same validation as the REST endpoints.
+type Store interface {
+	GetRoom(roomId string) (*models.Room, error)
+
+	CreateMessage(roomId, userId, content string) (*models.Message, error)
+	CreateGuestMessage(roomId, guestId, content string) (*models.Message, error)
+	UpdateMessage(roomId, messageId, userId, content string) (*models.Message, error)
+	RemoveMessage(roomId, messageId, userId string) (*models.Message, error)
+
+	// HasRoomPermission checks the member's current role against perm
+	HasRoomPermission(roomId, userId, perm string) (bool, error)
+	// RolesWithPermission lists the roles that grant perm
+	RolesWithPermission(perm string) []string
+	// OutranksMember reports whether the actor's role is above the target's
+	OutranksMember(roomId, actorId, targetId string) (bool, error)
+	// RolesOutranked lists the roles below the actor's
+	RolesOutranked(roomId, actorId string) ([]string, error)
+
+	// ReviewLobbyRequest admits or denies the user's pending join request
+	ReviewLobbyRequest(roomId, userId, reviewerId, reason string, admit bool) error
+
+	// BanFromRoom keeps a kicked user out of the room
+	BanFromRoom(roomId, userId, bannedBy, reason string) error
+
+	// Meeting sessions, called in the order things happened in the room
+	StartMeetingSession(roomId, sessionId string, startedAt time.Time) error
+	EndMeetingSession(roomId, sessionId string, endedAt time.Time) error
+	JoinMeetingSession(roomId, sessionId, userId, displayName string, isGuest bool, joinedAt time.Time) error
+	RejoinMeetingSession(roomId, sessionId, userId string) error
+	LeaveMeetingSession(roomId, sessionId, userId string, leftAt time.Time) error
+	// OpenMeetingSessions lists the meetings started before the given time
+	// that haven't ended
+	OpenMeetingSessions(startedBefore time.Time) ([]models.MeetingSession, error)
+	// OpenSessionParticipants lists the users who joined the meeting before
+	// the given time and are still in it
+	OpenSessionParticipants(sessionId string, joinedBefore time.Time) ([]string, error)
+	// RecordMeetingTelemetry adds call quality totals to the user's stretch
+	RecordMeetingTelemetry(roomId, sessionId, userId string, totals TelemetryTotals) error
+}
@@ -0,0 +1,367 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+)
+
+// Network quality levels carried in the Content of TypeNetworkQuality
+// messages, from best to worst
+const (
+	QualityExcellent = "excellent"
+	QualityGood      = "good"
+	QualityFair      = "fair"
+	QualityPoor      = "poor"
+	QualityBad       = "bad"
+)
+
+const (
+	// minReportInterval drops stats reports sent more often than this
+	minReportInterval = time.Second
+
+	// maxReportGap caps the time a report's bitrate is billed for, so a
+	// client that went quiet isn't charged for the silence
+	maxReportGap = 30 * time.Second
+
+	// maxBitrate is the highest bitrate accepted in a report, 100 Mbit/s
+	maxBitrate = 100_000_000
+
+	// participantSamples bounds the time series kept per participant, ten
+	// minutes at one report every five seconds
+	participantSamples = 120
+
+	// meetingSamples bounds the meeting's time series, one point every
+	// meetingSampleInterval for an hour
+	meetingSamples        = 360
+	meetingSampleInterval = 10 * time.Second
+
+	// scoreSmoothing weighs a new report against the running score, so a
+	// single bad report doesn't flip the level
+	scoreSmoothing = 0.3
+)
+
+var ErrInvalidStatsReport = errors.New("invalid stats report")
+
+// StatsReport is a client's summary of its WebRTC getStats, sent as the
+// payload of stats_report every few seconds
+type StatsReport struct {
+	BitrateSent     int64   `json:"bitrateSent"`     // In bits per second
+	BitrateReceived int64   `json:"bitrateReceived"` // In bits per second
+	PacketLoss      float64 `json:"packetLoss"`      // Fraction of packets lost, 0 to 1
+	RTT             float64 `json:"rtt"`             // In milliseconds
+	Jitter          float64 `json:"jitter"`          // In milliseconds
+	FrameWidth      int     `json:"frameWidth"`
+	FrameHeight     int     `json:"frameHeight"`
+}
+
+func (s *StatsReport) validate() error {
+	switch {
+	case s.BitrateSent < 0 || s.BitrateSent > maxBitrate,
+		s.BitrateReceived < 0 || s.BitrateReceived > maxBitrate,
+		s.PacketLoss < 0 || s.PacketLoss > 1,
+		s.RTT < 0 || s.RTT > 60_000,
+		s.Jitter < 0 || s.Jitter > 60_000,
+		s.FrameWidth < 0 || s.FrameWidth > 8192,
+		s.FrameHeight < 0 || s.FrameHeight > 8192:
+		return ErrInvalidStatsReport
+	}
+	return nil
+}
+
+// qualityScore estimates a mean opinion score, from 1 to 4.5, with a
+// simplified E-model of the connection's latency, jitter and loss
+func qualityScore(report *StatsReport) float64 {
+	latency := report.RTT/2 + 2*report.Jitter + 10
+
+	r := 93.2
+	if latency < 160 {
+		r -= latency / 40
+	} else {
+		r -= (latency - 120) / 10
+	}
+	r -= 2.5 * report.PacketLoss * 100
+	r = math.Max(0, math.Min(100, r))
+
+	mos := 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+	return math.Max(1, math.Min(4.5, mos))
+}
+
+func qualityLevel(score float64) string {
+	switch {
+	case score >= 4.0:
+		return QualityExcellent
+	case score >= 3.6:
+		return QualityGood
+	case score >= 3.1:
+		return QualityFair
+	case score >= 2.6:
+		return QualityPoor
+	default:
+		return QualityBad
+	}
+}
+
+// QualitySample is one stats report as it was received
+type QualitySample struct {
+	At time.Time `json:"at"`
+	StatsReport
+	Score float64 `json:"score"`
+}
+
+// MeetingSample is the state of the whole meeting at one point in time
+type MeetingSample struct {
+	At           time.Time `json:"at"`
+	Participants int       `json:"participants"`
+	Bitrate      int64     `json:"bitrate"` // Received, in bits per second
+	Score        float64   `json:"score"`   // Average of the participants' scores
+}
+
+// TelemetryTotals sums up a participant's reports over one stretch of a
+// meeting. Bytes only counts what they received: what one participant sends
+// is what the others receive, so adding both up would count it twice.
+type TelemetryTotals struct {
+	Bytes         int64   `json:"bytes"`
+	Reports       int     `json:"reports"`
+	AvgRTT        float64 `json:"avgRtt"`
+	AvgJitter     float64 `json:"avgJitter"`
+	AvgPacketLoss float64 `json:"avgPacketLoss"`
+	AvgScore      float64 `json:"avgScore"`
+}
+
+// participantQuality is what the room knows about one participant's
+// connection, owned by the room actor
+type participantQuality struct {
+	score   float64
+	level   string
+	samples []QualitySample
+
+	bytes                                int64
+	reports                              int
+	rttSum, jitterSum, lossSum, scoreSum float64
+}
+
+func (q *participantQuality) add(sample QualitySample, bytes int64) {
+	if q.reports == 0 {
+		q.score = sample.Score
+	} else {
+		q.score = scoreSmoothing*sample.Score + (1-scoreSmoothing)*q.score
+	}
+
+	q.samples = append(q.samples, sample)
+	if len(q.samples) > participantSamples {
+		q.samples = q.samples[len(q.samples)-participantSamples:]
+	}
+
+	q.bytes += bytes
+	q.reports++
+	q.rttSum += sample.RTT
+	q.jitterSum += sample.Jitter
+	q.lossSum += sample.PacketLoss
+	q.scoreSum += sample.Score
+}
+
+func (q *participantQuality) totals() TelemetryTotals {
+	totals := TelemetryTotals{Bytes: q.bytes, Reports: q.reports}
+	if q.reports > 0 {
+		n := float64(q.reports)
+		totals.AvgRTT = q.rttSum / n
+		totals.AvgJitter = q.jitterSum / n
+		totals.AvgPacketLoss = q.lossSum / n
+		totals.AvgScore = q.scoreSum / n
+	}
+	return totals
+}
+
+// ParticipantQuality is a participant's connection quality as reported to
+// the REST API
+type ParticipantQuality struct {
+	UserID  string          `json:"userId"`
+	Level   string          `json:"level"`
+	Score   float64         `json:"score"`
+	Totals  TelemetryTotals `json:"totals"`
+	Samples []QualitySample `json:"samples"`
+}
+
+// MeetingQuality is the call quality of a live meeting, as seen by the
+// participants connected to this instance
+type MeetingQuality struct {
+	SessionID    string               `json:"sessionId"`
+	Bytes        int64                `json:"bytes"`
+	Series       []MeetingSample      `json:"series"`
+	Participants []ParticipantQuality `json:"participants"`
+}
+
+// meetingTelemetry holds the quality reports of the meeting in progress
+type meetingTelemetry struct {
+	participants map[string]*participantQuality
+	series       []MeetingSample
+
+	// Bytes of participants who have left already
+	bytes int64
+}
+
+func newMeetingTelemetry() *meetingTelemetry {
+	return &meetingTelemetry{participants: make(map[string]*participantQuality)}
+}
+
+// handleStatsReport validates a client's stats and hands them to its room
+func (h *Hub) handleStatsReport(msg *Message, sender *Client) {
+	var report StatsReport
+	if err := json.Unmarshal(msg.Payload, &report); err != nil || report.validate() != nil {
+		h.sendError(sender, ErrInvalidStatsReport.Error())
+		return
+	}
+
+	sender.room.do(func() {
+		sender.room.recordStats(sender, &report, time.Now())
+	})
+}
+
+// recordStats adds a report to the sender's series and the meeting totals,
+// telling the room when the sender's quality level changes
+func (r *roomHub) recordStats(client *Client, report *StatsReport, now time.Time) {
+	if !r.clients[client] || r.session == "" {
+		return
+	}
+	if !client.statsAt.IsZero() && now.Sub(client.statsAt) < minReportInterval {
+		return
+	}
+
+	bytes := billedBytes(report, client.statsAt, now)
+	client.statsAt = now
+
+	quality, ok := r.telemetry.participants[client.userID]
+	if !ok {
+		quality = &participantQuality{}
+		r.telemetry.participants[client.userID] = quality
+	}
+	quality.add(QualitySample{At: now, StatsReport: *report, Score: qualityScore(report)}, bytes)
+	r.sampleMeeting(now)
+
+	level := qualityLevel(quality.score)
+	if level == quality.level {
+		return
+	}
+	quality.level = level
+	r.emit(envelope{RoomID: r.id, Ephemeral: true, Data: qualityMessage(r.id, client.userID, quality)})
+}
+
+// billedBytes is what a report adds to the participant's totals: the bytes
+// received at the report's bitrate since the connection's previous report.
+// The first report only starts the clock.
+func billedBytes(report *StatsReport, previous, now time.Time) int64 {
+	if previous.IsZero() {
+		return 0
+	}
+	elapsed := min(now.Sub(previous), maxReportGap)
+	return int64(float64(report.BitrateReceived) / 8 * elapsed.Seconds())
+}
+
+// sampleMeeting appends a point to the meeting's series once per interval
+func (r *roomHub) sampleMeeting(now time.Time) {
+	series := r.telemetry.series
+	if len(series) > 0 && now.Sub(series[len(series)-1].At) < meetingSampleInterval {
+		return
+	}
+
+	sample := MeetingSample{At: now}
+	for _, quality := range r.telemetry.participants {
+		last := quality.samples[len(quality.samples)-1]
+		sample.Participants++
+		sample.Bitrate += last.BitrateReceived
+		sample.Score += quality.score
+	}
+	if sample.Participants > 0 {
+		sample.Score /= float64(sample.Participants)
+	}
+
+	series = append(series, sample)
+	if len(series) > meetingSamples {
+		series = series[len(series)-meetingSamples:]
+	}
+	r.telemetry.series = series
+}
+
+func qualityMessage(roomID, userID string, quality *participantQuality) []byte {
+	payload, _ := json.Marshal(struct {
+		Score float64 `json:"score"`
+	}{math.Round(quality.score*100) / 100})
+
+	msg := Message{
+		Type:      TypeNetworkQuality,
+		RoomID:    roomID,
+		UserID:    userID,
+		Content:   quality.level,
+		Timestamp: time.Now(),
+		Payload:   payload,
+	}
+	jsonMsg, _ := json.Marshal(msg)
+	return jsonMsg
+}
+
+// showQuality tells a newly joined client the current quality level of
+// everyone who has reported one
+func (r *roomHub) showQuality(client *Client) {
+	for userID, quality := range r.telemetry.participants {
+		if quality.level == "" || userID == client.userID {
+			continue
+		}
+		r.send(client, 0, qualityMessage(r.id, userID, quality))
+	}
+}
+
+// flushTelemetry hands the totals of a participant who left to the store
+func (r *roomHub) flushTelemetry(userID string) {
+	quality, ok := r.telemetry.participants[userID]
+	if !ok {
+		return
+	}
+	delete(r.telemetry.participants, userID)
+	r.telemetry.bytes += quality.bytes
+
+	totals := quality.totals()
+	r.hub.recordSession(sessionEvent{
+		kind:      participantTelemetry,
+		roomID:    r.id,
+		sessionID: r.session,
+		userID:    userID,
+		telemetry: &totals,
+		at:        time.Now(),
+	})
+}
+
+// MeetingQuality returns the call quality of the room's live meeting, or
+// nil when nobody is connected to it on this instance
+func (h *Hub) MeetingQuality(roomID string) *MeetingQuality {
+	room := h.getRoom(roomID)
+	if room == nil {
+		return nil
+	}
+
+	var snapshot *MeetingQuality
+	room.do(func() {
+		if room.session == "" {
+			return
+		}
+
+		snapshot = &MeetingQuality{
+			SessionID:    room.session,
+			Bytes:        room.telemetry.bytes,
+			Series:       append([]MeetingSample(nil), room.telemetry.series...),
+			Participants: []ParticipantQuality{},
+		}
+		for userID, quality := range room.telemetry.participants {
+			snapshot.Bytes += quality.bytes
+			snapshot.Participants = append(snapshot.Participants, ParticipantQuality{
+				UserID:  userID,
+				Level:   quality.level,
+				Score:   quality.score,
+				Totals:  quality.totals(),
+				Samples: append([]QualitySample(nil), quality.samples...),
+			})
+		}
+	})
+	return snapshot
+}
@@ -0,0 +1,144 @@
+package websockets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsReportValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		report StatsReport
+		valid  bool
+	}{
+		{"empty", StatsReport{}, true},
+		{"typical", StatsReport{BitrateSent: 1_500_000, BitrateReceived: 2_500_000, PacketLoss: 0.01, RTT: 80, Jitter: 12, FrameWidth: 1280, FrameHeight: 720}, true},
+		{"highest values", StatsReport{BitrateSent: maxBitrate, BitrateReceived: maxBitrate, PacketLoss: 1, RTT: 60_000, Jitter: 60_000, FrameWidth: 8192, FrameHeight: 8192}, true},
+		{"negative bitrate sent", StatsReport{BitrateSent: -1}, false},
+		{"bitrate received too high", StatsReport{BitrateReceived: maxBitrate + 1}, false},
+		{"packet loss above one", StatsReport{PacketLoss: 1.5}, false},
+		{"negative packet loss", StatsReport{PacketLoss: -0.1}, false},
+		{"negative rtt", StatsReport{RTT: -1}, false},
+		{"jitter too high", StatsReport{Jitter: 60_001}, false},
+		{"frame too wide", StatsReport{FrameWidth: 8193}, false},
+		{"negative frame height", StatsReport{FrameHeight: -1}, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.report.validate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: got %v, want valid", tt.name, err)
+		}
+		if !tt.valid && err != ErrInvalidStatsReport {
+			t.Errorf("%s: got %v, want %v", tt.name, err, ErrInvalidStatsReport)
+		}
+	}
+}
+
+func TestQualityScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		report StatsReport
+		level  string
+	}{
+		{"perfect", StatsReport{}, QualityExcellent},
+		{"local network", StatsReport{RTT: 20, Jitter: 2}, QualityExcellent},
+		{"some loss", StatsReport{RTT: 100, Jitter: 10, PacketLoss: 0.05}, QualityGood},
+		{"far away", StatsReport{RTT: 300, Jitter: 40, PacketLoss: 0.02}, QualityGood},
+		{"lossy", StatsReport{RTT: 200, Jitter: 30, PacketLoss: 0.1}, QualityFair},
+		{"very lossy", StatsReport{RTT: 100, Jitter: 10, PacketLoss: 0.15}, QualityPoor},
+		{"unusable", StatsReport{RTT: 1000, Jitter: 200, PacketLoss: 0.3}, QualityBad},
+	}
+
+	for _, tt := range tests {
+		score := qualityScore(&tt.report)
+		if score < 1 || score > 4.5 {
+			t.Errorf("%s: score %.2f is outside 1 to 4.5", tt.name, score)
+		}
+		if level := qualityLevel(score); level != tt.level {
+			t.Errorf("%s: score %.2f is %s, want %s", tt.name, score, level, tt.level)
+		}
+	}
+
+	// Each impairment on its own makes the score worse
+	base := qualityScore(&StatsReport{RTT: 50, Jitter: 5, PacketLoss: 0.01})
+	for name, worse := range map[string]StatsReport{
+		"rtt":    {RTT: 300, Jitter: 5, PacketLoss: 0.01},
+		"jitter": {RTT: 50, Jitter: 60, PacketLoss: 0.01},
+		"loss":   {RTT: 50, Jitter: 5, PacketLoss: 0.05},
+	} {
+		if score := qualityScore(&worse); score >= base {
+			t.Errorf("more %s scored %.2f, not below %.2f", name, score, base)
+		}
+	}
+}
+
+func TestBilledBytes(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	report := &StatsReport{BitrateSent: 3_000_000, BitrateReceived: 800_000}
+
+	tests := []struct {
+		name     string
+		previous time.Time
+		want     int64
+	}{
+		{"first report", time.Time{}, 0},
+		{"five seconds later", now.Add(-5 * time.Second), 500_000},
+		{"half a second later", now.Add(-500 * time.Millisecond), 50_000},
+		{"after going quiet", now.Add(-10 * time.Minute), 3_000_000},
+	}
+
+	for _, tt := range tests {
+		if got := billedBytes(report, tt.previous, now); got != tt.want {
+			t.Errorf("%s: billed %d bytes, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMeetingBytesCountedOnce(t *testing.T) {
+	hub := NewHub(nil, fakeStore{})
+	alice := newTestClient("alice", 64)
+	bob := newTestClient("bob", 64)
+	hub.Register(alice)
+	hub.Register(bob)
+	receive(t, alice, TypeUserJoined)
+
+	// Alice sends bob 1 Mbit/s and receives 500 kbit/s from him, which he
+	// reports the other way around
+	start := time.Now()
+	reports := []struct {
+		client *Client
+		report StatsReport
+		at     time.Duration
+	}{
+		{alice, StatsReport{BitrateSent: 1_000_000, BitrateReceived: 500_000}, 0},
+		{bob, StatsReport{BitrateSent: 500_000, BitrateReceived: 1_000_000}, 0},
+		{alice, StatsReport{BitrateSent: 1_000_000, BitrateReceived: 500_000}, 4 * time.Second},
+		{bob, StatsReport{BitrateSent: 500_000, BitrateReceived: 1_000_000}, 4 * time.Second},
+		// Too soon after the previous report, dropped
+		{bob, StatsReport{BitrateSent: 500_000, BitrateReceived: 1_000_000}, 4*time.Second + 100*time.Millisecond},
+	}
+	for _, r := range reports {
+		client, report, at := r.client, r.report, start.Add(r.at)
+		client.room.do(func() { client.room.recordStats(client, &report, at) })
+	}
+
+	quality := hub.MeetingQuality("room")
+	if quality == nil {
+		t.Fatal("no live meeting quality")
+	}
+
+	// 4 seconds of 1.5 Mbit/s between the two of them
+	if want := int64(750_000); quality.Bytes != want {
+		t.Errorf("meeting transferred %d bytes, want %d", quality.Bytes, want)
+	}
+	for _, p := range quality.Participants {
+		want := map[string]int64{"alice": 250_000, "bob": 500_000}[p.UserID]
+		if p.Totals.Bytes != want {
+			t.Errorf("%s transferred %d bytes, want %d", p.UserID, p.Totals.Bytes, want)
+		}
+		if p.Totals.Reports != 2 {
+			t.Errorf("%s has %d reports, want 2", p.UserID, p.Totals.Reports)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package websockets
 
-import "time"
+import (
+    "encoding/json"
+    "time"
+)
 
 // MessageType represents different types of WebSocket messages
 type MessageType string
@@ -12,16 +15,96 @@ const (
     TypeUserJoined  MessageType = "user_joined"
     TypeUserLeft    MessageType = "user_left"
     TypeError       MessageType = "error"
+
+    // Chat changes, persisted before they are broadcast
+    TypeMessageEdited  MessageType = "message_edited"
+    TypeMessageDeleted MessageType = "message_deleted"
+
+    // Room configuration, sent on join and whenever an admin changes it
+    TypeRoomSettingsUpdated MessageType = "room_settings_updated"
+
+    // Screen sharing started or stopped, refused when the room disallows it
+    TypeScreenShare MessageType = "screen_share"
+
+    // Lobby: knock tells admins about a waiting user (Content is waiting,
+    // admitted, denied or left), admit and deny are their answers, and the
+    // waiting user learns the outcome through admitted or denied
+    TypeKnock    MessageType = "knock"
+    TypeAdmit    MessageType = "admit"
+    TypeDeny     MessageType = "deny"
+    TypeAdmitted MessageType = "admitted"
+    TypeDenied   MessageType = "denied"
+
+    // Moderation: kick removes a participant who is told with kicked, mute
+    // asks one participant or everyone to mute, lock toggles whether new
+    // participants can join and end_meeting disconnects everyone after
+    // meeting_ended
+    TypeKick          MessageType = "kick"
+    TypeKicked        MessageType = "kicked"
+    TypeMute          MessageType = "mute"
+    TypeLock          MessageType = "lock"
+    TypeEndMeeting    MessageType = "end_meeting"
+    TypeMeetingEnded  MessageType = "meeting_ended"
+
+    // Call quality: clients send stats_report with a summary of their WebRTC
+    // stats, the room is told about changes with network_quality
+    TypeStatsReport    MessageType = "stats_report"
+    TypeNetworkQuality MessageType = "network_quality"
+
+    // Speaker detection: clients send their microphone level with
+    // audio_level, the room is told who has the floor with active_speaker
+    TypeAudioLevel    MessageType = "audio_level"
+    TypeActiveSpeaker MessageType = "active_speaker"
+
+    // Personal notifications, delivered on all of a user's connections
+    TypeJoinRequestUpdated MessageType = "join_request_updated"
+
+    // Sent on resume when missed events can no longer be replayed
+    TypeResync MessageType = "resync"
+
+    // WebRTC signaling, delivered only to the peer named by TargetID
+    TypeOffer        MessageType = "offer"
+    TypeAnswer       MessageType = "answer"
+    TypeICECandidate MessageType = "ice_candidate"
+    TypeRenegotiate  MessageType = "renegotiate"
+
+    // Media relay signaling, used instead of peer-to-peer offers when the
+    // server runs an SFU. Clients send sfu_join, answer every sfu_offer with
+    // sfu_answer, trickle their candidates with sfu_candidate and disconnect
+    // with sfu_leave. Remote tracks carry the publisher's user ID as their
+    // stream ID.
+    TypeSFUJoin      MessageType = "sfu_join"
+    TypeSFUOffer     MessageType = "sfu_offer"
+    TypeSFUAnswer    MessageType = "sfu_answer"
+    TypeSFUCandidate MessageType = "sfu_candidate"
+    TypeSFULeave     MessageType = "sfu_leave"
 )
 
 // Message represents the structure of all WebSocket messages
 type Message struct {
+    // Seq is the room sequence number of events that can be replayed
+    Seq       uint64      `json:"seq,omitempty"`
     Type      MessageType `json:"type"`
     RoomID    string     `json:"roomId"`
     UserID    string     `json:"userId"`
+    TargetID  string     `json:"targetId,omitempty"`
+    // TargetClientID narrows TargetID down to one of the user's connections
+    TargetClientID string `json:"targetClientId,omitempty"`
     Content   string     `json:"content"`
     Timestamp time.Time  `json:"timestamp"`
     Metadata  Metadata   `json:"metadata,omitempty"`
+
+    // Payload carries the SDP or ICE candidate of signaling messages as-is
+    Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// IsSignaling reports whether the message type is a peer-to-peer WebRTC signal
+func (t MessageType) IsSignaling() bool {
+    switch t {
+    case TypeOffer, TypeAnswer, TypeICECandidate, TypeRenegotiate:
+        return true
+    }
+    return false
 }
 
 // Metadata contains additional message information
@@ -31,4 +114,9 @@ type Metadata struct {
     MessageID   string   `json:"messageId,omitempty"`
     UserName    string   `json:"userName,omitempty"`
     UserAvatar  string   `json:"userAvatar,omitempty"`
+    // ClientID identifies the sending connection when a user has several
+    ClientID    string   `json:"clientId,omitempty"`
+    IsGuest     bool     `json:"isGuest,omitempty"`
+    // Ban also removes a kicked participant from the room
+    Ban         bool     `json:"ban,omitempty"`
 }
\ No newline at end of file
@@ -1,12 +1,13 @@
 package main
 
 import (
-	"log"
 	"net/http"
 	"video-chat/internal/auth"
 	"video-chat/internal/config"
 	"video-chat/internal/database"
+	"video-chat/internal/mailer"
 	"video-chat/internal/room"
+	"video-chat/internal/sfu"
 	"video-chat/internal/utils"
 	"video-chat/internal/websockets"
 
@@ -29,17 +30,33 @@ func main() {
 	// Connect to Redis Client
 	redisClient := config.NewRedisClient(*cfg)
 
+	// Email delivery, SMTP or a local outbox depending on MAIL_DRIVER
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		panic("Failed to set up email delivery: " + err.Error())
+	}
+
 	// Initialize Services
 	authService := auth.NewAuthServer(db)
 	roomService := room.NewRoomService(db)
 
-	// Initialize handler
-	authHandler := auth.NewAuthHandler(authService, redisClient)
-	roomHandler := room.NewRoomHandler(roomService, redisClient)
-
-	hub := websockets.NewHub()
+	hub := websockets.NewHub(redisClient, roomService)
 	go hub.Run()
 
+	// Relay media through the built-in SFU instead of a peer-to-peer mesh.
+	// Only one replica relays at a time, the others fall back to the mesh.
+	if cfg.SFU_ENABLED == "true" {
+		media, err := sfu.FromConfig(cfg)
+		if err != nil {
+			panic("Failed to start the SFU: " + err.Error())
+		}
+		hub.UseSFU(media)
+	}
+
+	// Initialize handler
+	authHandler := auth.NewAuthHandler(authService, redisClient, mail)
+	roomHandler := room.NewRoomHandler(roomService, redisClient, hub, mail)
+
 	r := gin.Default()
 
 	// Add rate limiter
@@ -60,7 +77,7 @@ func main() {
 	var ALLOWED_HOSTS []string = []string{"http://localhost:4173", "http://localhost:5173", "http://localhost:3000"}
 	corsConfig := cors.New(cors.Config{
 		AllowOrigins:     ALLOWED_HOSTS,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Content-Type", "Authorization", "Token"},
 		ExposeHeaders:    []string{"Origin", "Token", "Authorization"},
 		AllowCredentials: true,
@@ -77,12 +94,22 @@ func main() {
 	r.POST("/api/verify-account", authHandler.VerifyAccount)
 	r.POST("/api/verify-otp", authHandler.VerifyOTP)
 
+	// Guest access, authorized by the guest token itself
+	r.GET("/api/guest/:token", roomHandler.GetGuestPass)
+	r.GET("/api/guest/:token/ws", roomHandler.ServeGuestWebsocket)
+
 	protectedRoutes := r.Group("/api")
 	protectedRoutes.Use(authHandler.AuthMiddleware(redisClient))
 	{
 		protectedRoutes.POST("/delete-account", authHandler.DeleteAccount)
 		protectedRoutes.GET("/user", authHandler.ProfileDetails)
 
+		// Sessions
+		protectedRoutes.POST("/logout", authHandler.Logout)
+		protectedRoutes.GET("/sessions", authHandler.ListSessions)
+		protectedRoutes.POST("/sessions/revoke-others", authHandler.RevokeOtherSessions)
+		protectedRoutes.DELETE("/sessions/:sessionId", authHandler.RevokeSession)
+
 		roomRoutes := protectedRoutes.Group("/rooms")
 		{
 			// Get room Lists
@@ -91,12 +118,21 @@ func main() {
 			// Create Room and invite user in case of private
 			roomRoutes.POST("/create", roomHandler.CreateRoom)
 
+			// Update room settings
+			roomRoutes.PATCH("/:roomId", roomHandler.RequirePermission(room.PermChangeSettings), roomHandler.UpdateRoomSettings)
+
 			// Delete Room
-			roomRoutes.DELETE("/:roomId", roomHandler.DeleteRoom)
+			roomRoutes.DELETE("/:roomId", roomHandler.RequirePermission(room.PermDeleteRoom), roomHandler.DeleteRoom)
 
 			// Leave Room
 			roomRoutes.POST("/:roomId/leave", roomHandler.LeaveRoom)
 
+			// Promote or demote a member below the caller's own role
+			roomRoutes.PATCH("/:roomId/members/:userId", roomHandler.RequirePermission(room.PermManageRoles), roomHandler.ChangeMemberRole)
+
+			// Hand the room to another member
+			roomRoutes.POST("/:roomId/transfer-ownership", roomHandler.RequirePermission(room.PermTransferOwnership), roomHandler.TransferOwnership)
+
 			// Accept Invited Rooms
 			roomRoutes.POST("/:roomId/accept", roomHandler.AcceptRoomInvite)
 
@@ -106,16 +142,53 @@ func main() {
 			// Request to join room
 			roomRoutes.POST("/:roomId/join", roomHandler.RequestToJoin)
 			
+			// Join a password protected room
+			roomRoutes.POST("/:roomId/join-password", roomHandler.JoinWithPassword)
+
 			// Get Request to joined rooms
 			roomRoutes.GET("/:roomId/join-request", roomHandler.GetJoinRequest)
 
+			// List, approve and reject join requests
+			roomRoutes.GET("/:roomId/join-requests", roomHandler.RequirePermission(room.PermApproveJoins), roomHandler.ListJoinRequests)
+			roomRoutes.POST("/:roomId/join-requests/:requestId/approve", roomHandler.RequirePermission(room.PermApproveJoins), roomHandler.ApproveJoinRequest)
+			roomRoutes.POST("/:roomId/join-requests/:requestId/reject", roomHandler.RequirePermission(room.PermApproveJoins), roomHandler.RejectJoinRequest)
+
 			// Cancel Join Request Room
 			roomRoutes.POST("/:roomId/cancel-join", roomHandler.CancelJoinReqest)
 
+			// Invite users
+			roomRoutes.POST("/:roomId/invite", roomHandler.RequirePermission(room.PermInvite), roomHandler.InviteUsers)
+
+			// Shareable invite links
+			roomRoutes.POST("/:roomId/invite-links", roomHandler.RequirePermission(room.PermInvite), roomHandler.CreateInviteLink)
+			roomRoutes.GET("/:roomId/invite-links", roomHandler.RequirePermission(room.PermInvite), roomHandler.ListInviteLinks)
+			roomRoutes.DELETE("/:roomId/invite-links/:linkId", roomHandler.RequirePermission(room.PermCancelInvite), roomHandler.RevokeInviteLink)
+
+			// Issue a guest token
+			roomRoutes.POST("/:roomId/guests", roomHandler.RequirePermission(room.PermInvite), roomHandler.IssueGuestToken)
+
+			// Ban users, list and lift bans
+			roomRoutes.POST("/:roomId/bans", roomHandler.RequirePermission(room.PermManageBans), roomHandler.BanUser)
+			roomRoutes.GET("/:roomId/bans", roomHandler.RequirePermission(room.PermManageBans), roomHandler.ListBans)
+			roomRoutes.DELETE("/:roomId/bans/:banId", roomHandler.RequirePermission(room.PermManageBans), roomHandler.LiftBan)
+
+			// Room stats, meeting history and live call quality
+			roomRoutes.GET("/:roomId/stats", roomHandler.RequireMember(), roomHandler.GetRoomStats)
+			roomRoutes.GET("/:roomId/sessions", roomHandler.RequireMember(), roomHandler.ListMeetingSessions)
+			roomRoutes.GET("/:roomId/sessions/:sessionId", roomHandler.RequireMember(), roomHandler.GetMeetingSession)
+			roomRoutes.GET("/:roomId/quality", roomHandler.RequireMember(), roomHandler.GetMeetingQuality)
+
+			// Export a meeting's attendance as JSON or CSV
+			roomRoutes.GET("/:roomId/sessions/:sessionId/attendance", roomHandler.RequirePermission(room.PermViewAttendance), roomHandler.ExportAttendance)
+
 			// Cancel invites
-			roomRoutes.POST("/:roomId/cancel-invite", roomHandler.CancelInvite)
+			roomRoutes.POST("/:roomId/cancel-invite", roomHandler.RequirePermission(room.PermCancelInvite), roomHandler.CancelInvite)
 		}
 
+		// Preview and redeem an invite link
+		protectedRoutes.GET("/invite-links/:token", roomHandler.GetInviteLink)
+		protectedRoutes.POST("/invite-links/:token/redeem", roomHandler.RedeemInviteLink)
+
 		messageRoutes := protectedRoutes.Group("/messages")
 		{
 			// Send Messages
@@ -131,23 +204,11 @@ func main() {
 			messageRoutes.PUT("/:roomId/:messageId", roomHandler.EditMessage)
 		}
 
-		protectedRoutes.GET("/ws/:roomId", func(c *gin.Context) {
-			roomId := c.Param("roomId")
-			userId := c.GetString("userId")
-			userName := c.GetString("userName")
-		
-			conn, err := websockets.Upgrader.Upgrade(c.Writer, c.Request, nil)
-			if err != nil {
-				log.Printf("error upgrading to websocket: %v", err)
-				return
-			}
-		
-			client := websockets.NewClient(hub, conn, roomId, userId, userName)
-			client.Hub.Register <- client
-		
-			go client.WritePump()
-			go client.ReadPump()
-		})
+		// Join a room's live session
+		protectedRoutes.GET("/ws/:roomId", roomHandler.ServeWebsocket)
+
+		// Wait in a room's lobby to be admitted
+		protectedRoutes.GET("/ws/:roomId/lobby", roomHandler.ServeLobbyWebsocket)
 	}
 
 	r.Run(":" + PORT)